@@ -1,5 +1,5 @@
 module github.com/asig/pupusolver
 
-go 1.18
+go 1.21
 
 require github.com/veandco/go-sdl2 v0.4.40
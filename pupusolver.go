@@ -24,15 +24,36 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"container/heap"
 	_ "embed"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	_ "image/gif"
-	_ "image/png"
+	"image/png"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unsafe"
 
 	"github.com/veandco/go-sdl2/img"
 	"github.com/veandco/go-sdl2/sdl"
@@ -42,16 +63,93 @@ const (
 	tileW = 16
 	tileH = 16
 
+	// Number of tiles in tiles.png
+	nofTileSprites = 12
+
 	playfieldW = 12
 	playfieldH = 12
 )
 
 var (
-	flagLevelData  = flag.String("level", "", "level data")
-	flagScreenshot = flag.String("screenshot", "", "Load level data from screenshot")
-	flagZoom       = flag.Int("zoom", 3, "Zoom factor between 1 and 10")
+	flagLevelData       = flag.String("level", "", "level data")
+	flagLevelB64        = flag.String("level-base64", "", "level data, base64 encoded (optionally gzipped)")
+	flagLevelJSON       = flag.String("level-json", "", "Load level data from a JSON file with {\"rows\": [...]}")
+	flagLevelBin        = flag.String("level-bin", "", "Load level data from a binary file with one byte per cell (see README)")
+	flagScreenshot      = flag.String("screenshot", "", "Load level data from screenshot")
+	flagCrop            = flag.String("crop", "", "Only look at this part of the screenshot, as x,y,w,h")
+	flagShotTolerance   = flag.Int("screenshot-tolerance", 0, "Number of pixels per tile that may differ from the tileset when reading screenshots")
+	flagShotBlack       = flag.Int("screenshot-black", 0, "Treat pixels with no color channel brighter than this (0-255) as black when reading screenshots, e.g. for a dark gray border")
+	flagBoardIndex      = flag.Int("board-index", 0, "If the screenshot contains several boards, read this one (starting at 1)")
+	flagShotFlipped     = flag.Bool("screenshot-flipped", false, "Also try reading the screenshot mirrored, upside down, and rotated by 180 degrees, for emulators that flip the display")
+	flagSaveShot        = flag.String("save-screenshot", "", "Save the board at the end of the solution as PNG, in the same format -screenshot reads")
+	flagDumpStates      = flag.String("dump-states", "", "Write the boards of all steps of the solution as text to this file")
+	flagSVGDir          = flag.String("svg-dir", "", "Write every step of the solution as SVG to this directory")
+	flagEncode          = flag.Bool("encode-level", false, "Print the level as gzipped base64 (for -level-base64) and exit")
+	flagPack            = flag.String("pack", "", "Solve the level files given as arguments, and write them with their solutions to this level pack")
+	flagUnpack          = flag.String("unpack", "", "Print the levels and solutions in this level pack")
+	flagVerifyPack      = flag.String("verify-pack", "", "Check that the solutions in this level pack still solve their levels")
+	flagTileset         = flag.String("tileset", "", "Load tile graphics from this PNG instead of using the built-in ones")
+	flagPalette         = flag.String("palette", "classic", "Tile graphics: classic (PUPU's sprites) or high-contrast")
+	flagAutoplay        = flag.Bool("autoplay", false, "Start the viewer playing the solution, instead of paused")
+	flagPlaybackMS      = flag.Int("playback-ms", 500, "Time between steps when playing the solution in the viewer, in milliseconds")
+	flagBgColor         = flag.String("bg-color", "404040", "Color of the viewer's background, as RRGGBB")
+	flagExitAfter       = flag.Duration("exit-after", 0, "Close the viewer after this time, e.g. 10s (0 = keep it open)")
+	flagExitWhenSolved  = flag.Bool("exit-when-solved", false, "Close the viewer after playing the solution (implies -autoplay)")
+	flagNoFlash         = flag.Bool("no-flash", false, "Don't flash the tiles that get cleared when stepping through the solution in the viewer")
+	flagZoom            = flag.Int("zoom", 3, "Zoom factor between 1 and 10")
+	flagAlgo            = flag.String("algo", "bfs", "Search algorithm: bfs (shortest solution), greedy (fast, but not necessarily shortest), or astar (in between, see -weight)")
+	flagWeight          = flag.Float64("weight", 1, "With -algo=astar, how much the number of tiles left counts compared to the number of moves made (0 = like bfs, higher = more like greedy)")
+	flagAstarBound      = flag.Bool("astar-bound", false, "With -algo=astar, estimate the moves left with a lower bound instead of the number of tiles left. The bound looks one move ahead, so it expands fewer playfields than -algo=bfs, but each one takes longer")
+	flagBeam            = flag.Int("beam", 0, "With -algo=bfs, only keep the K boards with the fewest tiles left per move (0 = keep all). Uses less memory, but might miss the shortest solution")
+	flagExecute         = flag.String("execute", "", "After solving, send the moves to the game with this executor (only \"log\" so far)")
+	flagExecuteDelay    = flag.Duration("execute-delay", 500*time.Millisecond, "Time to wait before each move sent with -execute")
+	flagSideBySide      = flag.String("side-by-side", "", "Also solve with this algorithm, and show both solutions next to each other in the viewer")
+	flagOptimize        = flag.String("optimize", "", "With -algo=bfs, set to \"moves\" to pick the shortest solution that moves tiles the least")
+	flagMaxMoves        = flag.Int("max-moves", 0, "Don't look for solutions longer than this (0 = no limit)")
+	flagTimeLimit       = flag.Duration("time-limit", 0, "Give up searching after this time, e.g. 30s (0 = no limit)")
+	flagConnectivity    = flag.Int("connectivity", 4, "Tiles form a group with 4 (orthogonal) or 8 (also diagonal) neighbours")
+	flagLocked          = flag.String("locked", "", "Cells whose tiles can't be moved, as space separated x,y pairs, e.g. \"3,4 5,6\"")
+	flagIgnoreTiles     = flag.String("ignore-tiles", "", "Treat these tile types (e.g. TR) as walls, and only solve for the others")
+	flagGoalTile        = flag.String("goal-tile", "", "Only clear all tiles of this type (e.g. H), instead of all tiles")
+	flagGoalRemaining   = flag.Int("goal-remaining", 0, "Consider the level solved when at most N tiles are left")
+	flagMaxBranching    = flag.Int("max-branching", 0, "Only try the N moves that leave the fewest tiles on every board (0 = try all). Faster on open boards, but might miss the shortest solution")
+	flagPrune           = flag.Bool("prune", false, "Skip long slides where nothing happens. Faster, but might miss the shortest solution")
+	flagNoSolvPrune     = flag.Bool("no-solvability-prune", false, "Don't skip boards where a tile type occurs only once")
+	flagEstimate        = flag.Bool("estimate", false, "Only look at the first few moves, and estimate how many boards a full search would need to look at")
+	flagNoDedup         = flag.Bool("no-dedup", false, "Experimental, for analysis only: don't skip boards that were seen before, only cycles (implies -cycle-guard)")
+	flagCycleGuard      = flag.Bool("cycle-guard", false, "Skip boards that already occurred earlier on their own path. Only needed if boards aren't deduplicated")
+	flagEnumerate       = flag.Int("enumerate-depth", 0, "Print the boards that take exactly K moves to reach and aren't obviously unsolvable, instead of solving")
+	flagEnumerateLimit  = flag.Int("enumerate-limit", 100, "With -enumerate-depth, print at most this many boards")
+	flagCompare         = flag.Bool("compare-algos", false, "Solve the level with all algorithms, and compare how they did")
+	flagCountSols       = flag.Bool("count-solutions", false, "Count the distinct shortest solutions instead of showing one")
+	flagCountLimit      = flag.Int("count-limit", 1000000, "Stop counting solutions at this number")
+	flagFormat          = flag.String("format", "human", "Output format of the result: human, json, or csv")
+	flagSeenKey         = flag.String("seen-key", "compact", "How to store the boards seen so far: tiles, zobrist (least memory, but might miss solutions), or compact")
+	flagCompareSeen     = flag.Bool("compare-seen-keys", false, "Solve the level with all -seen-key settings, and compare their speed and memory use")
+	flagVerbose         = flag.Bool("verbose", false, "Also print how much memory the search used")
+	flagLogLevel        = flag.String("log-level", "info", "Log level: debug, info, warn, or error")
+	flagLogJSON         = flag.Bool("log-json", false, "Log in JSON format")
+	flagExplain         = flag.Bool("explain", false, "Log why successors were discarded (implies -log-level=debug)")
+	flagForceGUI        = flag.Bool("force-gui", false, "Fail if the viewer can't be opened, instead of just printing the solution")
+	flagQueueStats      = flag.Int("dump-queue-stats", 0, "Log queue histograms every N playfields (implies -log-level=debug)")
+	flagDumpDeque       = flag.Int("dump-deque", 0, "With -dump-queue-stats and -algo=bfs, also print the first N boards in the queue")
+	flagFrozen          = flag.Bool("frozen", false, "Allow frozen tiles (lower case letters, '!' and '@'), which thaw when a group next to them is cleared")
+	flagStatsCSV        = flag.String("stats-csv", "", "Write the number of analyzed states, the queue size, and the number of seen states to this CSV file during the search")
+	flagStatsEvery      = flag.Int("stats-every", 1000, "With -stats-csv, write a line every N states")
+	flagCheckpoint      = flag.String("checkpoint", "", "Regularly save the search state to this file, to continue later with -resume")
+	flagCheckpointEvery = flag.Duration("checkpoint-interval", 10*time.Minute, "How often to save the search state with -checkpoint")
+	flagResume          = flag.String("resume", "", "Continue the search from a file written with -checkpoint")
+	flagCheckDead       = flag.Bool("check-dead", false, "Before solving, check for tiles that can never reach a partner because of walls, and bail out if there are any")
+	flagCPUProfile      = flag.String("cpuprofile", "", "Write a CPU profile of the search to this file")
+	flagMemProfile      = flag.String("memprofile", "", "Write a memory profile after the search to this file")
+	flagPlay            = flag.Bool("play", false, "Don't solve the level, play it yourself")
+	flagUndoLimit       = flag.Int("undo-limit", 1000, "With -play, how many moves can be undone")
+	flagValidateSol     = flag.String("validate-solution", "", "Only check that the solution in this file (move notation, or JSON from -format=json) solves the level, don't solve it")
+	flagValidate        = flag.Bool("validate", false, "Only check that the level is well-formed, don't solve it")
 
 	zoom int
+	// Number of playfields shown next to each other in the viewer
+	viewerBoards = 1
 )
 
 // ================================================
@@ -72,16 +170,59 @@ const (
 	tile7                 // F(rame)
 	tile8                 // G(lassblock)
 	tileWall              // '#' (Wall)
-	tileBg                // 'P'(attern)
-	tileEmpty             // '.'
+	tileBg                // 'P'(attern): outside of the level, solid like a wall
+	tileEmpty             // '.': the only cells tiles can move or fall into
+
+	// Tiles below have no sprite in tiles.png
+	tileBlocker // 'B'(locker): can be erased and falls, but can't be moved
 )
 
+// tileFrozen is or'ed to a tile to freeze it (see -frozen). Frozen tiles
+// can't be moved, erased, or fall, until a group next to them is cleared.
+const tileFrozen tile = 1 << 8
+
+var tileNames = []string{
+	tile0:       "Heart",
+	tile1:       "Diamond",
+	tile2:       "Triangle",
+	tile3:       "Ring",
+	tile4:       "Cross #1",
+	tile5:       "Sandglass",
+	tile6:       "Cross #2",
+	tile7:       "Frame",
+	tile8:       "Glassblock",
+	tileWall:    "Wall",
+	tileBg:      "Background",
+	tileEmpty:   "Empty",
+	tileBlocker: "Blocker",
+}
+
+func (t tile) name() string {
+	if t.isFrozen() {
+		return "Frozen " + t.base().name()
+	}
+	return tileNames[t]
+}
+
+func (t tile) isFrozen() bool {
+	return t&tileFrozen != 0
+}
+
+// base returns the tile without its frozen flag.
+func (t tile) base() tile {
+	return t &^ tileFrozen
+}
+
 func (t tile) isMobile() bool {
 	return t >= tile0 && t <= tile8
 }
 
 func (t tile) isErasable() bool {
-	return t >= tile0 && t <= tile7
+	return t >= tile0 && t <= tile7 || t == tileBlocker
+}
+
+func (t tile) canFall() bool {
+	return t.isMobile() || t == tileBlocker
 }
 
 // ================================================
@@ -93,7 +234,15 @@ var (
 	charToTile = make(map[rune]tile)
 )
 
+// addTileMapping panics if r or t are already mapped, so that typos can't
+// silently overwrite another tile's mapping.
 func addTileMapping(r rune, t tile) {
+	if t2, found := charToTile[r]; found {
+		panic(fmt.Sprintf("'%c' is used for both %s and %s", r, t2.name(), t.name()))
+	}
+	if r2, found := tileToChar[t]; found {
+		panic(fmt.Sprintf("%s has two characters, '%c' and '%c'", t.name(), r2, r))
+	}
 	tileToChar[t] = r
 	charToTile[r] = t
 }
@@ -111,6 +260,24 @@ func initTileMap() {
 	addTileMapping('#', tileWall)
 	addTileMapping('P', tileBg)
 	addTileMapping('.', tileEmpty)
+	addTileMapping('B', tileBlocker)
+	if *flagFrozen {
+		addTileMapping('h', tile0|tileFrozen)
+		addTileMapping('d', tile1|tileFrozen)
+		addTileMapping('t', tile2|tileFrozen)
+		addTileMapping('r', tile3|tileFrozen)
+		addTileMapping('!', tile4|tileFrozen)
+		addTileMapping('s', tile5|tileFrozen)
+		addTileMapping('@', tile6|tileFrozen)
+		addTileMapping('f', tile7|tileFrozen)
+	}
+
+	// Every tile needs a character, or dumpStr can't write it
+	for t := tile0; t <= tileBlocker; t++ {
+		if _, found := tileToChar[t]; !found {
+			panic(fmt.Sprintf("no character for tile %s", t.name()))
+		}
+	}
 }
 
 type move struct {
@@ -118,52 +285,376 @@ type move struct {
 	toX          int
 }
 
+// less defines the canonical move order: by fromY, then fromX, then toX.
+func (m move) less(o move) bool {
+	if m.fromY != o.fromY {
+		return m.fromY < o.fromY
+	}
+	if m.fromX != o.fromX {
+		return m.fromX < o.fromX
+	}
+	return m.toX < o.toX
+}
+
 type tiles [playfieldH + 2][playfieldW + 2]tile
 type playfield struct {
 	tiles tiles
 	path  []move
+	// Cells whose tiles can't be moved (see -locked). Never changes
+	// during a search, so it's shared by all playfields, and not part of
+	// the state.
+	locked *[playfieldH][playfieldW]bool
+	// Cached number of erasable tiles per type, frozen ones included. nil
+	// if not known yet, see tileCounts.
+	counts *[tileBlocker + 1]int
+	// The part of the board that tiles can be in, see withBounds. nil
+	// means the whole board.
+	bounds *area
+}
+
+// area is a rectangle of cells, from (minX,minY) to (maxX,maxY) inclusive.
+type area struct{ minX, minY, maxX, maxY int }
+
+// area returns the part of the board that needs to be looked at for
+// moves, drops, and clears.
+func (pf *playfield) area() area {
+	if pf.bounds != nil {
+		return *pf.bounds
+	}
+	return area{0, 0, playfieldW - 1, playfieldH - 1}
+}
+
+// withBounds returns a copy of the playfield that only looks at the
+// smallest area that contains all cells that are not walls or background.
+// Tiles can never get into walls or background, so all the playfields
+// reached from it can share that area.
+func (pf *playfield) withBounds() *playfield {
+	a := area{playfieldW, playfieldH, -1, -1}
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			if !pf.isBarrier(x, y) {
+				a = area{min(a.minX, x), min(a.minY, y), max(a.maxX, x), max(a.maxY, y)}
+			}
+		}
+	}
+	pf2 := pf.clone()
+	pf2.bounds = &a
+	return pf2
+}
+
+// equal compares the cells of the playfield, ignoring the border.
+func (a tiles) equal(b tiles) bool {
+	return len(diffTiles(a, b)) == 0
+}
+
+// Random keys for Zobrist hashing: one per cell and tile type, and one per
+// cell for frozen tiles.
+var (
+	zobristKeys   [playfieldH][playfieldW][tileBlocker + 1]uint64
+	zobristFrozen [playfieldH][playfieldW]uint64
+)
+
+func init() {
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			for t := range zobristKeys[y][x] {
+				zobristKeys[y][x][t] = rnd.Uint64()
+			}
+			zobristFrozen[y][x] = rnd.Uint64()
+		}
+	}
+}
+
+// hash returns the Zobrist hash of the cells of the playfield, ignoring the
+// border.
+func (a tiles) hash() uint64 {
+	var h uint64
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			t := a[y+1][x+1]
+			h ^= zobristKeys[y][x][t.base()]
+			if t.isFrozen() {
+				h ^= zobristFrozen[y][x]
+			}
+		}
+	}
+	return h
+}
+
+// Hash returns a hash of the board, which can be used to dedupe playfields.
+// The path is ignored. Equal boards have equal hashes within the same
+// process, but hashes might change between versions of pupusolver, so don't
+// store them.
+func (pf *playfield) Hash() uint64 {
+	return pf.tiles.hash()
+}
+
+// diffTiles returns the positions of all cells that differ.
+func diffTiles(a, b tiles) []pos {
+	var res []pos
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			if a[y+1][x+1] != b[y+1][x+1] {
+				res = append(res, pos{x, y})
+			}
+		}
+	}
+	return res
 }
 
 func (pf *playfield) clone() *playfield {
 	pf2 := playfield{}
 	pf2.tiles = pf.tiles
 	pf2.path = append(pf2.path, pf.path...)
+	pf2.locked = pf.locked
+	pf2.bounds = pf.bounds
 	return &pf2
 }
 
+// moveLocked moves the locked cells along with the tiles when mirroring or
+// rotating. f maps old to new coordinates.
+func (pf *playfield) moveLocked(f func(x, y int) (int, int)) {
+	if pf.locked == nil {
+		return
+	}
+	var locked [playfieldH][playfieldW]bool
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			x2, y2 := f(x, y)
+			locked[y2][x2] = pf.locked[y][x]
+		}
+	}
+	pf.locked = &locked
+}
+
+// mirrorX flips the playfield left to right. Physics don't care, so the
+// path is mirrored as well and stays valid.
+func (pf *playfield) mirrorX() *playfield {
+	pf2 := pf.clone()
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			pf2.set(playfieldW-1-x, y, pf.get(x, y))
+		}
+	}
+	pf2.moveLocked(func(x, y int) (int, int) { return playfieldW - 1 - x, y })
+	for i, m := range pf2.path {
+		pf2.path[i] = move{fromY: m.fromY, fromX: playfieldW - 1 - m.fromX, toX: playfieldW - 1 - m.toX}
+	}
+	return pf2
+}
+
+// mirrorY flips the playfield upside down. As tiles fall down, the path is
+// meaningless afterwards and is dropped.
+func (pf *playfield) mirrorY() *playfield {
+	pf2 := pf.clone()
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			pf2.set(x, playfieldH-1-y, pf.get(x, y))
+		}
+	}
+	pf2.moveLocked(func(x, y int) (int, int) { return x, playfieldH - 1 - y })
+	pf2.path = nil
+	return pf2
+}
+
+// rotate90 rotates the playfield clockwise. Like with mirrorY, the path is
+// dropped.
+func (pf *playfield) rotate90() *playfield {
+	pf2 := pf.clone()
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			pf2.set(playfieldH-1-y, x, pf.get(x, y))
+		}
+	}
+	pf2.moveLocked(func(x, y int) (int, int) { return playfieldH - 1 - y, x })
+	pf2.path = nil
+	return pf2
+}
+
+// EventKind tells what happened in an Event.
+type EventKind int
+
+const (
+	EventMove  EventKind = iota // the tile the player moved
+	EventDrop                   // a tile fell down
+	EventClear                  // a group of tiles was cleared
+)
+
+// Event describes one thing that happened while applying a move.
+type Event struct {
+	Kind EventKind
+	// Pass is the number of the drop or clear pass within the move, starting
+	// at 1. Drops and clears alternate until the board is stable. 0 for
+	// EventMove.
+	Pass int
+	Tile tile
+	// Where the tile came from and went to, for EventMove and EventDrop.
+	From, To pos
+	// The cleared cells, for EventClear.
+	Cells []pos
+	// The frozen cells next to the cleared ones that thawed, for EventClear.
+	Thawed []pos
+}
+
 func (pf *playfield) apply(m move) *playfield {
+	return pf.doApply(m, nil)
+}
+
+// applyWithEvents works like apply, but also returns everything that
+// happened, in order: the move itself, and then all drop and clear passes.
+func (pf *playfield) applyWithEvents(m move) (*playfield, []Event) {
+	var events []Event
+	pf2 := pf.doApply(m, &events)
+	return pf2, events
+}
+
+// applyTraced works like apply, but returns the intermediate boards: after
+// the tile was moved, and after every drop and clear pass. The last one is
+// the final board.
+func (pf *playfield) applyTraced(m move) []*playfield {
+	_, events := pf.applyWithEvents(m)
+	var res []*playfield
+	cur := pf.clone()
+	for i, ev := range events {
+		switch ev.Kind {
+		case EventMove, EventDrop:
+			cur.set(ev.From.x, ev.From.y, tileEmpty)
+			cur.set(ev.To.x, ev.To.y, ev.Tile)
+		case EventClear:
+			for _, p := range ev.Cells {
+				cur.set(p.x, p.y, tileEmpty)
+			}
+			for _, p := range ev.Thawed {
+				cur.set(p.x, p.y, cur.get(p.x, p.y).base())
+			}
+		}
+		if i == len(events)-1 || events[i+1].Kind != ev.Kind || events[i+1].Pass != ev.Pass {
+			// end of a pass
+			res = append(res, cur)
+			cur = cur.clone()
+		}
+	}
+	res[len(res)-1].path = append(res[len(res)-1].path, m)
+	return res
+}
+
+// doApply applies the move, and records events if events is not nil.
+func (pf *playfield) doApply(m move, events *[]Event) *playfield {
 	pf2 := pf.clone()
 	pf2.path = append(pf2.path, m)
 
 	y := m.fromY
 
+	// Moves and drops don't change the counts, so only clears need to be
+	// subtracted.
+	var counts [tileBlocker + 1]int
+	if pf.counts != nil {
+		counts = *pf.counts
+	} else {
+		counts = *pf.tileCounts()
+	}
+	defer func() { pf2.counts = &counts }()
+
 	t := pf2.get(m.fromX, y)
 	pf2.set(m.fromX, y, tileEmpty)
 	pf2.set(m.toX, y, t)
+	if events != nil {
+		*events = append(*events, Event{Kind: EventMove, Tile: t, From: pos{m.fromX, y}, To: pos{m.toX, y}})
+	}
 
+	// Only cells that got a new tile can be part of a new group. The start
+	// board is the exception, it might come with groups that were never
+	// removed, so we do a full scan the first time.
+	fullScan := len(pf.path) == 0
+	changedCells := []pos{{m.toX, y}}
+	dropPass, clearPass := 0, 0
 	for {
 		// drop all the tiles that can drop
-		changed := pf2.dropTiles()
+		drops := pf2.dropTiles()
+		if len(drops) > 0 {
+			dropPass++
+			for _, d := range drops {
+				changedCells = append(changedCells, d.To)
+				if events != nil {
+					d.Pass = dropPass
+					*events = append(*events, d)
+				}
+			}
+			continue
+		}
 
 		// remove all the tiles that can be removed
-		changed = changed || pf2.removeTiles()
-
-		if !changed {
+		var clears []Event
+		if fullScan {
+			clears = pf2.removeTiles()
+			fullScan = false
+		} else {
+			clears = pf2.removeTilesNear(changedCells)
+		}
+		if len(clears) == 0 {
 			return pf2
 		}
+		clearPass++
+		changedCells = changedCells[:0]
+		for i := range clears {
+			counts[clears[i].Tile] -= len(clears[i].Cells)
+			pf2.thaw(&clears[i])
+			// Thawed tiles might already form a group where they are
+			changedCells = append(changedCells, clears[i].Thawed...)
+		}
+		if events != nil {
+			for _, c := range clears {
+				c.Pass = clearPass
+				*events = append(*events, c)
+			}
+		}
 	}
 }
 
+func (pf *playfield) isLocked(x, y int) bool {
+	return pf.locked != nil && pf.locked[y][x]
+}
+
 func (pf *playfield) get(x, y int) tile {
 	return pf.tiles[y+1][x+1]
 }
 
 func (pf *playfield) set(x, y int, t tile) {
 	pf.tiles[y+1][x+1] = t
+	pf.counts = nil
+}
+
+// tileCounts returns the number of erasable tiles per type, counting frozen
+// tiles as their unfrozen type. The result must not be changed.
+func (pf *playfield) tileCounts() *[tileBlocker + 1]int {
+	if pf.counts != nil {
+		return pf.counts
+	}
+	var cnts [tileBlocker + 1]int
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			t := pf.get(x, y).base()
+			if t.isErasable() {
+				cnts[t]++
+			}
+		}
+	}
+	pf.counts = &cnts
+	return pf.counts
 }
 
 type pos struct{ x, y int }
 
+var (
+	neighbors4 = []pos{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	neighbors8 = []pos{{-1, 0}, {1, 0}, {0, -1}, {0, 1}, {-1, -1}, {1, -1}, {-1, 1}, {1, 1}}
+
+	// neighbors are the offsets of the cells that count as adjacent for
+	// groups (see -connectivity).
+	neighbors = neighbors4
+)
+
 func (pf *playfield) extendTileset(t tile, p pos, set map[pos]bool) {
 	if _, found := set[p]; found {
 		// Already been here!
@@ -174,43 +665,110 @@ func (pf *playfield) extendTileset(t tile, p pos, set map[pos]bool) {
 		return
 	}
 	set[p] = true
-	pf.extendTileset(t, pos{p.x - 1, p.y}, set)
-	pf.extendTileset(t, pos{p.x + 1, p.y}, set)
-	pf.extendTileset(t, pos{p.x, p.y - 1}, set)
-	pf.extendTileset(t, pos{p.x, p.y + 1}, set)
+	for _, d := range neighbors {
+		pf.extendTileset(t, pos{p.x + d.x, p.y + d.y}, set)
+	}
 }
 
-func (pf *playfield) removeTiles() bool {
-	changed := false
-	for y := 0; y < playfieldH; y++ {
-		for x := 0; x < playfieldW; x++ {
+// clearGroup removes the tiles in set, and returns the corresponding event.
+func (pf *playfield) clearGroup(t tile, set map[pos]bool) Event {
+	ev := Event{Kind: EventClear, Tile: t}
+	for p := range set {
+		pf.set(p.x, p.y, tileEmpty)
+		ev.Cells = append(ev.Cells, p)
+	}
+	sort.Slice(ev.Cells, func(i, j int) bool {
+		a, b := ev.Cells[i], ev.Cells[j]
+		return a.y < b.y || a.y == b.y && a.x < b.x
+	})
+	return ev
+}
+
+// thaw unfreezes all frozen tiles next to the cells cleared by ev, and
+// records them in ev.Thawed. This is done after all groups of a pass are
+// cleared, so thawed tiles never join a group in the same pass.
+func (pf *playfield) thaw(ev *Event) {
+	for _, p := range ev.Cells {
+		for _, d := range neighbors {
+			p2 := pos{p.x + d.x, p.y + d.y}
+			if t := pf.get(p2.x, p2.y); t.isFrozen() {
+				pf.set(p2.x, p2.y, t.base())
+				ev.Thawed = append(ev.Thawed, p2)
+			}
+		}
+	}
+}
+
+// removeTiles removes all groups of 2 or more same tiles, and returns an
+// EventClear for every removed group.
+func (pf *playfield) removeTiles() []Event {
+	var clears []Event
+	// Cells that are already part of a group we looked at
+	visited := make(map[pos]bool)
+	a := pf.area()
+	for y := a.minY; y <= a.maxY; y++ {
+		for x := a.minX; x <= a.maxX; x++ {
 			t := pf.get(x, y)
 			if !t.isErasable() {
 				continue
 			}
-			// Find all same tiles around this one
 			p := pos{x, y}
+			if visited[p] {
+				continue
+			}
+			// Find all same tiles around this one
 			set := make(map[pos]bool)
 			pf.extendTileset(t, p, set)
+			for p := range set {
+				visited[p] = true
+			}
 
 			if len(set) >= 2 {
 				// More than 2 tiles, remove them
-				changed = true
-				for p := range set {
-					pf.set(p.x, p.y, tileEmpty)
-				}
+				clears = append(clears, pf.clearGroup(t, set))
 			}
 		}
 	}
-	return changed
+	return clears
 }
 
-func (pf *playfield) dropTiles() bool {
-	changed := false
-	for y := playfieldH - 1; y > 0; y-- {
-		for x := 0; x < playfieldW; x++ {
+// removeTilesNear works like removeTiles, but only looks for groups
+// containing one of the given cells.
+func (pf *playfield) removeTilesNear(cells []pos) []Event {
+	var clears []Event
+	visited := make(map[pos]bool)
+	for _, p := range cells {
+		t := pf.get(p.x, p.y)
+		if !t.isErasable() {
+			// Also covers cells that were already cleared
+			continue
+		}
+		if visited[p] {
+			continue
+		}
+		set := make(map[pos]bool)
+		pf.extendTileset(t, p, set)
+		for p := range set {
+			visited[p] = true
+		}
+
+		if len(set) >= 2 {
+			clears = append(clears, pf.clearGroup(t, set))
+		}
+	}
+	return clears
+}
+
+// dropTiles lets all tiles fall as far as they can, and returns an
+// EventDrop for every tile that fell. Tiles only fall into empty cells, the
+// background around the level is as solid as walls.
+func (pf *playfield) dropTiles() []Event {
+	var drops []Event
+	a := pf.area()
+	for y := a.maxY; y >= a.minY; y-- {
+		for x := a.minX; x <= a.maxX; x++ {
 			t := pf.get(x, y)
-			if t.isMobile() && pf.get(x, y+1) == tileEmpty {
+			if t.canFall() && pf.get(x, y+1) == tileEmpty {
 				// let it fall
 				y2 := y
 				for pf.get(x, y2+1) == tileEmpty {
@@ -218,117 +776,577 @@ func (pf *playfield) dropTiles() bool {
 				}
 				pf.set(x, y, tileEmpty)
 				pf.set(x, y2, t)
-				changed = true
+				drops = append(drops, Event{Kind: EventDrop, Tile: t, From: pos{x, y}, To: pos{x, y2}})
 			}
 		}
 	}
-	return changed
+	return drops
 }
 
+// anyTile as goalTile means that all erasable tiles need to be cleared.
+const anyTile tile = -1
+
+// goalTile is the tile type that needs to be cleared to solve a level (see
+// -goal-tile).
+var goalTile = anyTile
+
+// goalRemaining is the number of tiles that may be left on a solved level
+// (see -goal-remaining).
+var goalRemaining = 0
+
 func (pf *playfield) isSolved() bool {
-	for y := 0; y < playfieldH; y++ {
-		for x := 0; x < playfieldW; x++ {
-			t := pf.get(x, y)
-			if t >= tile0 && t <= tile7 {
-				return false
-			}
+	left := 0
+	for t, cnt := range pf.tileCounts() {
+		if goalTile == anyTile || tile(t) == goalTile {
+			left += cnt
 		}
 	}
-	return true
+	return left <= goalRemaining
 }
 
+// isSolvable tells whether the level might still be solved, i.e. whether not
+// more than goalRemaining tiles are the last of their type.
 func (pf *playfield) isSolvable() bool {
-	cnts := make([]int, 8)
-	for y := 0; y < playfieldH; y++ {
-		for x := 0; x < playfieldW; x++ {
-			t := pf.get(x, y)
-			if t >= tile0 && t <= tile7 {
-				cnts[t]++
-			}
-		}
-	}
-	for _, cnt := range cnts {
-		if cnt == 1 {
-			return false
+	stuck := 0
+	for t, cnt := range pf.tileCounts() {
+		if cnt == 1 && (goalTile == anyTile || tile(t) == goalTile) {
+			stuck++
 		}
 	}
-	return true
-
+	return stuck <= goalRemaining
 }
 
-func (pf *playfield) possibleMoves() []move {
-	var moves []move
-
+// chambers splits the playfield into areas separated by walls and
+// background, and returns the number of the area for every cell inside of
+// one. Tiles can never leave their chamber. With -connectivity=8, areas
+// that only touch diagonally count as one, as tiles in them can still form
+// a group.
+func (pf *playfield) chambers() map[pos]int {
+	res := make(map[pos]int)
+	cnt := 0
 	for y := 0; y < playfieldH; y++ {
 		for x := 0; x < playfieldW; x++ {
-			t := pf.get(x, y)
-			if !t.isMobile() {
+			if _, found := res[pos{x, y}]; found || pf.isBarrier(x, y) {
 				continue
 			}
-
-			// Generate all moves
-			for _, dirX := range []int{-1, 1} {
-				x2 := x + dirX
-				for pf.get(x2, y) == tileEmpty {
-					// We can move here!
-					moves = append(moves, move{fromY: y, fromX: x, toX: x2})
-					if pf.get(x2, y+1) == tileEmpty || pf.get(x2, y+1) == t {
-						// Floor or same tile: we're done
-						break
+			cnt++
+			todo := []pos{{x, y}}
+			res[pos{x, y}] = cnt
+			for len(todo) > 0 {
+				p := todo[len(todo)-1]
+				todo = todo[:len(todo)-1]
+				for _, d := range neighbors {
+					p2 := pos{p.x + d.x, p.y + d.y}
+					if _, found := res[p2]; found || pf.isBarrier(p2.x, p2.y) {
+						continue
 					}
-					x2 += dirX
+					res[p2] = cnt
+					todo = append(todo, p2)
 				}
 			}
 		}
 	}
-	return moves
+	return res
 }
 
-func (pf *playfield) render(r *sdl.Renderer) {
-	r.SetDrawColor(0, 255, 55, 255)
-	r.Clear()
+// isBarrier tells whether tiles can never pass the cell.
+func (pf *playfield) isBarrier(x, y int) bool {
+	if x < 0 || y < 0 || x >= playfieldW || y >= playfieldH {
+		return true
+	}
+	t := pf.get(x, y)
+	return t == tileWall || t == tileBg
+}
+
+// deadTiles returns the erasable tiles that are the only one of their type
+// in their chamber. These can never be cleared, even if there are more of
+// them elsewhere. With -goal-tile, only tiles of that type are returned, as
+// the others don't need to be cleared.
+func (pf *playfield) deadTiles() []pos {
+	chambers := pf.chambers()
+	type key struct {
+		chamber int
+		t       tile
+	}
+	cnts := make(map[key]int)
 	for y := 0; y < playfieldH; y++ {
 		for x := 0; x < playfieldW; x++ {
-			t := pf.get(x, y)
-			srcRect := &sdl.Rect{X: int32(t * tileW), Y: 0, W: tileW, H: tileH}
-			dstRect := &sdl.Rect{X: int32(x * tileW * zoom), Y: int32(y * tileH * zoom), W: int32(tileW * zoom), H: int32(tileH * zoom)}
-			r.Copy(tilesTexture, srcRect, dstRect)
+			if t := pf.get(x, y).base(); t.isErasable() {
+				cnts[key{chambers[pos{x, y}], t}]++
+			}
+		}
+	}
+	var res []pos
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			t := pf.get(x, y).base()
+			if goalTile != anyTile && t != goalTile {
+				continue
+			}
+			if t.isErasable() && cnts[key{chambers[pos{x, y}], t}] == 1 {
+				res = append(res, pos{x, y})
+			}
 		}
 	}
+	return res
+}
 
-	// Handle all the pending events so that the screen
-	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+// remainingTiles returns how many tiles of every erasable type are still on
+// the board. Frozen tiles are counted as their unfrozen type.
+func (pf *playfield) remainingTiles() map[tile]int {
+	cnts := make(map[tile]int)
+	for t, cnt := range pf.tileCounts() {
+		if cnt > 0 {
+			cnts[tile(t)] = cnt
+		}
+	}
+	return cnts
+}
+
+// moveDistance returns how far tiles were moved in total along the path.
+func (pf *playfield) moveDistance() int {
+	dist := 0
+	for _, m := range pf.path {
+		dist += abs(m.toX - m.fromX)
 	}
+	return dist
+}
 
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
 }
 
-func (pf *playfield) dumpStr() string {
-	var sb strings.Builder
-	for y := 0; y < playfieldH; y++ {
-		for x := 0; x < playfieldW; x++ {
-			sb.WriteRune(tileToChar[pf.get(x, y)])
+// neighborTiles returns which kinds of mobile tiles are next to p.
+func (pf *playfield) neighborTiles(p pos) map[tile]bool {
+	res := make(map[tile]bool)
+	for _, d := range neighbors {
+		if t := pf.get(p.x+d.x, p.y+d.y); t.isMobile() || t.isErasable() {
+			res[t] = true
 		}
-		sb.WriteByte('\n')
 	}
-	return sb.String()
+	return res
 }
 
-func (pf *playfield) dump() {
-	fmt.Printf("%s", pf.dumpStr())
+// isUseful tells whether a move is worth looking into. Moves where the
+// tile just slides along for more than one cell without anything falling
+// down or getting cleared, and without meeting new kinds of tiles, are not:
+// the same board can be reached by sliding one cell at a time. events are
+// the move's events, as returned by applyWithEvents, and pf2 the resulting
+// playfield.
+func (pf *playfield) isUseful(pf2 *playfield, events []Event) bool {
+	if len(events) > 1 {
+		// Drops and clears always change the board significantly
+		return true
+	}
+	if abs(events[0].To.x-events[0].From.x) == 1 {
+		return true
+	}
+	before := pf.neighborTiles(events[0].From)
+	for t := range pf2.neighborTiles(events[0].To) {
+		if !before[t] {
+			return true
+		}
+	}
+	return false
 }
 
-func (pf *playfield) fill(t tile) {
+// groupReport lists the sizes of the connected groups for every erasable
+// tile type, largest first.
+func (pf *playfield) groupReport() map[tile][]int {
+	res := make(map[tile][]int)
+	visited := make(map[pos]bool)
 	for y := 0; y < playfieldH; y++ {
 		for x := 0; x < playfieldW; x++ {
-			pf.tiles[y][x] = t
+			t := pf.get(x, y)
+			p := pos{x, y}
+			if !t.isErasable() || visited[p] {
+				continue
+			}
+			set := make(map[pos]bool)
+			pf.extendTileset(t, p, set)
+			for p := range set {
+				visited[p] = true
+			}
+			res[t] = append(res[t], len(set))
 		}
 	}
+	for _, sizes := range res {
+		sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	}
+	return res
 }
 
-func badLevelData() {
-	fmt.Fprintf(os.Stderr, `Bad level data, needs to be 12 lines of 12 chars per line.
-
-Valid characters:
+// lowerBoundMoves returns a number of moves that is guaranteed to be needed
+// at least to solve the playfield, so that A* with it finds the shortest
+// solution. Counting the groups that need to be merged doesn't work for
+// that: one move can start a chain reaction that clears several groups, or
+// even the whole board. So instead, we look one move ahead: if no move
+// solves the playfield, it takes at least two.
+func (pf *playfield) lowerBoundMoves() int {
+	if pf.isSolved() {
+		return 0
+	}
+	for _, m := range pf.possibleMoves() {
+		if pf.apply(m).isSolved() {
+			return 1
+		}
+	}
+	return 2
+}
+
+// revisits tells whether the board already occurred on the way from
+// startPf to pf, i.e. whether pf's path contains a cycle. The seen set
+// catches these as well, so this only matters if boards aren't
+// deduplicated.
+func (pf *playfield) revisits(startPf *playfield) bool {
+	cur := startPf
+	for _, m := range pf.path {
+		if cur.tiles == pf.tiles {
+			return true
+		}
+		cur = cur.apply(m)
+	}
+	return false
+}
+
+// heuristic estimates how far the playfield is from being solved. Used to
+// order the frontier in the non-BFS search algorithms.
+func (pf *playfield) heuristic() int {
+	cnt := 0
+	for _, c := range pf.tileCounts() {
+		cnt += c
+	}
+	return cnt
+}
+
+// possibleMoves returns all legal moves in canonical order (see move.less),
+// so that the same level always yields the same solution, no matter how
+// the moves were generated.
+func (pf *playfield) possibleMoves() []move {
+	var moves []move
+
+	a := pf.area()
+	for y := a.minY; y <= a.maxY; y++ {
+		for x := a.minX; x <= a.maxX; x++ {
+			t := pf.get(x, y)
+			if !t.isMobile() || pf.isLocked(x, y) {
+				continue
+			}
+
+			// Generate all moves
+			for _, dirX := range []int{-1, 1} {
+				x2 := x + dirX
+				for pf.get(x2, y) == tileEmpty {
+					// We can move here!
+					moves = append(moves, move{fromY: y, fromX: x, toX: x2})
+					if pf.get(x2, y+1) == tileEmpty {
+						// No floor: tile falls down, we're done
+						break
+					}
+					// Same tiles above can't be next to an empty cell, they
+					// would have fallen down, so only the ones below matter.
+					if t.isErasable() && pf.get(x2, y+1) == t {
+						// Same tile below: tile gets cleared, we're done.
+						// Glassblocks are never cleared, so they can pass.
+						break
+					}
+					if t.isErasable() && len(neighbors) == 8 && pf.get(x2+dirX, y+1) == t {
+						// Same tile diagonally ahead. Diagonally behind was
+						// checked in the previous cell already.
+						break
+					}
+					x2 += dirX
+				}
+			}
+		}
+	}
+	sort.Slice(moves, func(i, j int) bool { return moves[i].less(moves[j]) })
+	return moves
+}
+
+// mostPromising returns the k moves that leave the fewest tiles, in
+// canonical order. Moves that make the level unsolvable come last.
+func (pf *playfield) mostPromising(moves []move, k int) []move {
+	remaining := make(map[move]int)
+	for _, m := range moves {
+		pf2 := pf.apply(m)
+		remaining[m] = pf2.heuristic()
+		if !pf2.isSolvable() {
+			remaining[m] += playfieldW * playfieldH
+		}
+	}
+	res := append([]move(nil), moves...)
+	sort.SliceStable(res, func(i, j int) bool { return remaining[res[i]] < remaining[res[j]] })
+	res = res[:k]
+	sort.Slice(res, func(i, j int) bool { return res[i].less(res[j]) })
+	return res
+}
+
+// helpfulDepth is how many moves helpfulMoves looks ahead.
+const helpfulDepth = 3
+
+// helpfulMoves returns the moves that keep the playfield solvable, and that
+// clear tiles, either right away or within helpfulDepth moves.
+func (pf *playfield) helpfulMoves() []move {
+	var res []move
+	remaining := pf.heuristic()
+	for _, m := range pf.possibleMoves() {
+		pf2 := pf.apply(m)
+		if pf2.isSolvable() && pf2.makesProgress(remaining, helpfulDepth-1) {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// makesProgress tells whether pf, or any playfield reachable from it in up to
+// depth moves, has fewer than remaining tiles.
+func (pf *playfield) makesProgress(remaining, depth int) bool {
+	if pf.heuristic() < remaining {
+		return true
+	}
+	if depth == 0 {
+		return false
+	}
+	for _, m := range pf.possibleMoves() {
+		if pf.apply(m).makesProgress(remaining, depth-1) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pf *playfield) render(r *sdl.Renderer) {
+	r.SetDrawColor(bgColor.R, bgColor.G, bgColor.B, 255)
+	r.Clear()
+	pf.renderAt(0, r)
+
+	// Handle all the pending events so that the screen
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+	}
+
+}
+
+// renderAt draws the playfield offsetX pixels from the left of the window.
+func (pf *playfield) renderAt(offsetX int, r *sdl.Renderer) {
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			t := pf.get(x, y)
+			dstRect := &sdl.Rect{X: int32(offsetX + x*tileW*zoom), Y: int32(y * tileH * zoom), W: int32(tileW * zoom), H: int32(tileH * zoom)}
+			renderSprite(t, dstRect, r)
+			if pf.isLocked(x, y) {
+				// Red frame
+				r.SetDrawColor(255, 0, 0, 255)
+				for i := 0; i < zoom; i++ {
+					r.DrawRect(&sdl.Rect{X: dstRect.X + int32(i), Y: dstRect.Y + int32(i), W: dstRect.W - int32(2*i), H: dstRect.H - int32(2*i)})
+				}
+			}
+			if t.isFrozen() {
+				// Icy overlay
+				r.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+				r.SetDrawColor(frozenColor.R, frozenColor.G, frozenColor.B, frozenColor.A)
+				r.FillRect(dstRect)
+				r.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+			}
+		}
+	}
+}
+
+// renderSprite draws t's sprite (without the frozen overlay) to dstRect,
+// which must be a tile's size.
+func renderSprite(t tile, dstRect *sdl.Rect, r *sdl.Renderer) {
+	switch {
+	case *flagPalette == "high-contrast":
+		renderTileShape(t.base(), dstRect, r)
+	case t.base() == tileBlocker:
+		// No sprite for blockers, just draw a framed block
+		r.SetDrawColor(0, 0, 0, 255)
+		r.FillRect(dstRect)
+		r.SetDrawColor(255, 140, 0, 255)
+		r.FillRect(&sdl.Rect{X: dstRect.X + int32(zoom), Y: dstRect.Y + int32(zoom), W: dstRect.W - int32(2*zoom), H: dstRect.H - int32(2*zoom)})
+	default:
+		srcRect := &sdl.Rect{X: int32(t.base() * tileW), Y: 0, W: tileW, H: tileH}
+		r.Copy(tilesTexture, srcRect, dstRect)
+	}
+}
+
+// frozenColor is drawn over frozen tiles.
+var frozenColor = color.NRGBA{160, 220, 255, 160}
+
+// bgColor is the viewer's background (see -bg-color).
+var bgColor = color.RGBA{64, 64, 64, 255}
+
+// highlightColor marks moves in the viewer.
+var highlightColor = color.RGBA{0, 255, 55, 255}
+
+// parseColor parses a color given as RRGGBB.
+func parseColor(s string) (color.RGBA, error) {
+	var r, g, b uint8
+	if len(s) != 6 {
+		return color.RGBA{}, fmt.Errorf("color must be RRGGBB")
+	}
+	if _, err := fmt.Sscanf(s, "%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.RGBA{}, fmt.Errorf("color must be RRGGBB: %w", err)
+	}
+	return color.RGBA{r, g, b, 255}, nil
+}
+
+// toImage renders the playfield without SDL, one pixel per tile pixel.
+func (pf *playfield) toImage() *image.RGBA {
+	tilesImg := tilesImage()
+	res := image.NewRGBA(image.Rect(0, 0, playfieldW*tileW, playfieldH*tileH))
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			t := pf.get(x, y)
+			dstRect := image.Rect(x*tileW, y*tileH, (x+1)*tileW, (y+1)*tileH)
+			if t.base() == tileBlocker {
+				// Same framed block as in render()
+				draw.Draw(res, dstRect, image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+				draw.Draw(res, dstRect.Inset(1), image.NewUniform(color.RGBA{255, 140, 0, 255}), image.Point{}, draw.Src)
+			} else {
+				draw.Draw(res, dstRect, tilesImg, image.Pt(int(t.base())*tileW, 0), draw.Src)
+			}
+			if t.isFrozen() {
+				draw.Draw(res, dstRect, image.NewUniform(frozenColor), image.Point{}, draw.Over)
+			}
+		}
+	}
+	return res
+}
+
+// toSVG draws the playfield as SVG, with a rectangle per tile in the high
+// contrast palette's colors, and an arrow for m if it's not nil.
+func (pf *playfield) toSVG(m *move) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\">\n", playfieldW*tileW, playfieldH*tileH)
+	sb.WriteString("<defs><marker id=\"arrow\" viewBox=\"0 0 10 10\" refX=\"5\" refY=\"5\" markerWidth=\"4\" markerHeight=\"4\" orient=\"auto\"><path d=\"M0,0 L10,5 L0,10 z\" fill=\"black\"/></marker></defs>\n")
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			t := pf.get(x, y)
+			style := highContrastStyles[t.base()]
+			fmt.Fprintf(&sb, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\" stroke=\"black\"/>\n", x*tileW, y*tileH, tileW, tileH, style.r, style.g, style.b)
+			if t.isFrozen() {
+				fmt.Fprintf(&sb, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\" fill-opacity=\"%.2f\"/>\n", x*tileW, y*tileH, tileW, tileH, frozenColor.R, frozenColor.G, frozenColor.B, float64(frozenColor.A)/255)
+			}
+		}
+	}
+	if m != nil {
+		y := m.fromY*tileH + tileH/2
+		fmt.Fprintf(&sb, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"black\" stroke-width=\"2\" marker-end=\"url(#arrow)\"/>\n", m.fromX*tileW+tileW/2, y, m.toX*tileW+tileW/2, y)
+	}
+	sb.WriteString("</svg>\n")
+	return sb.String()
+}
+
+// writeSVGs writes the boards of the solution as step-001.svg, step-002.svg,
+// ... to dir, with the move to make on each of them.
+func writeSVGs(dir string, startPf *playfield, moves []move) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	pf := startPf
+	for i := 0; i <= len(moves); i++ {
+		var m *move
+		if i < len(moves) {
+			m = &moves[i]
+		}
+		path := filepath.Join(dir, fmt.Sprintf("step-%03d.svg", i+1))
+		if err := os.WriteFile(path, []byte(pf.toSVG(m)), 0644); err != nil {
+			return err
+		}
+		if m != nil {
+			pf = pf.apply(*m)
+		}
+	}
+	return nil
+}
+
+func (pf *playfield) dumpStr() string {
+	var sb strings.Builder
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			sb.WriteRune(tileToChar[pf.get(x, y)])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func (pf *playfield) dump() {
+	fmt.Printf("%s", pf.dumpStr())
+}
+
+// writeStates writes the boards of the solution to path, as shown by dump,
+// with the move made on each of them.
+func writeStates(path string, startPf *playfield, moves []move) error {
+	var sb strings.Builder
+	pf := startPf
+	for i := 0; i <= len(moves); i++ {
+		if i < len(moves) {
+			m := moves[i]
+			fmt.Fprintf(&sb, "=== Step %d: (%d,%d)->(%d,%d)\n", i+1, m.fromX, m.fromY, m.toX, m.fromY)
+		} else {
+			fmt.Fprintf(&sb, "=== Step %d: final board\n", i+1)
+		}
+		sb.WriteString(pf.dumpStr())
+		if i < len(moves) {
+			pf = pf.apply(moves[i])
+		}
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
+
+// replace turns all tiles of type from into to, and returns how many there
+// were.
+func (pf *playfield) replace(from, to tile) int {
+	cnt := 0
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			if pf.get(x, y) == from {
+				pf.set(x, y, to)
+				cnt++
+			}
+		}
+	}
+	return cnt
+}
+
+// ignoreTiles turns the tiles with the given characters into walls, frozen
+// ones included (see -ignore-tiles).
+func (pf *playfield) ignoreTiles(chars string) error {
+	for _, c := range chars {
+		t, found := charToTile[c]
+		if !found || !t.isMobile() && !t.isErasable() {
+			return fmt.Errorf("'%c' is not a tile that can be moved or cleared", c)
+		}
+		// Walls never move, fall, or get cleared, so that's exactly what we need
+		cnt := pf.replace(t, tileWall) + pf.replace(t|tileFrozen, tileWall)
+		slog.Info("Ignoring tiles", "tile", t.name(), "count", cnt)
+	}
+	return nil
+}
+
+// fill sets all cells, including the border, to t.
+func (pf *playfield) fill(t tile) {
+	for y := range pf.tiles {
+		for x := range pf.tiles[y] {
+			pf.tiles[y][x] = t
+		}
+	}
+	pf.counts = nil
+}
+
+// errBadLevel is returned by playfieldFromString for malformed level data.
+var errBadLevel = errors.New("bad level data")
+
+// badLevelData explains the level format, and exits.
+func badLevelData() {
+	fmt.Fprintf(os.Stderr, `Level data needs to be 12 lines of 12 chars per line.
+
+Valid characters:
 
 	tile0     tile = iota // H(eart)
 	tile1                 // D(iamond)
@@ -342,6 +1360,7 @@ Valid characters:
 	tileWall              // '#' (Wall)
 	tileBg                // 'P'(attern)
 	tileEmpty             // '.'
+	tileBlocker           // 'B'(locker)
 
 'H' -> Heart tile
 'D' -> Diamond tile
@@ -354,6 +1373,7 @@ Valid characters:
 '#' -> Wall
 'P' -> Background/Pattern
 '.' -> Empty
+'B' -> Blocker (can be cleared, but not moved)
 
 Example data (Level 93):
 
@@ -373,7 +1393,8 @@ PPPPPPPPPPPP
 	os.Exit(1)
 }
 
-func playfieldFromString(text string) *playfield {
+// playfieldFromString parses a level in text form. Errors wrap errBadLevel.
+func playfieldFromString(text string) (*playfield, error) {
 	var lines []string
 	for _, l := range strings.Split(text, "\n") {
 		l = strings.TrimSpace(l)
@@ -383,162 +1404,557 @@ func playfieldFromString(text string) *playfield {
 	}
 
 	if len(lines) != playfieldH {
-		badLevelData()
+		return nil, fmt.Errorf("%w: got %d lines instead of %d", errBadLevel, len(lines), playfieldH)
 	}
 
 	var res playfield
 	res.fill(tileBg)
 	for y, l := range lines {
-		if len(l) != playfieldW {
-			badLevelData()
+		row := []rune(l)
+		if len(row) != playfieldW {
+			return nil, fmt.Errorf("%w: line %d has %d chars instead of %d", errBadLevel, y+1, len(row), playfieldW)
 		}
-		for x, c := range l {
+		for x, c := range row {
 			t, found := charToTile[c]
 			if !found {
-				fmt.Fprintf(os.Stderr, "'%c' is not a valid tile.\n", c)
-				badLevelData()
+				return nil, fmt.Errorf("%w: '%c' is not a valid tile", errBadLevel, c)
 			}
 			res.set(x, y, t)
 		}
 	}
-	return &res
+	return &res, nil
 }
 
-func colToInt(c color.Color) int {
-	r, g, b, _ := c.RGBA()
-	if r == 0 && g == 0 && b == 0 {
-		return 0
+// decodeLevel turns the -level-base64 data back into the text form. The
+// data can be gzipped before base64 encoding, as done by encodeLevel.
+func decodeLevel(b64 string) string {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(b64))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't decode base64 level data: %v\n", err)
+		os.Exit(1)
 	}
-	return 1
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		// gzip magic
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err == nil {
+			data, err = io.ReadAll(r)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't decompress level data: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	return string(data)
 }
 
-func playfieldFromScreenshot(screenshot string) *playfield {
-	// First, load the tiles for comparison
-	r := bytes.NewReader(tilesData)
-	img, _, err := image.Decode(r)
+// levelFromJSON reads a level from a JSON file of the form
+// {"rows": ["PPPPPPPPPPPP", ...]} and returns it in text form.
+func levelFromJSON(path string) (string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	nofTiles := 12
-	tileLineW := nofTiles * tileW
-	var tilesPix = make([]int, tileLineW*tileH)
-	for y := 0; y < tileH; y++ {
-		for x := 0; x < 11*tileW; x++ {
-			tilesPix[y*tileLineW+x] = colToInt(img.At(x, y))
+	var level struct {
+		Rows []string `json:"rows"`
+	}
+	if err := json.Unmarshal(data, &level); err != nil {
+		return "", fmt.Errorf("can't parse %s: %w", path, err)
+	}
+	if len(level.Rows) != playfieldH {
+		return "", fmt.Errorf("%s: need %d rows, got %d", path, playfieldH, len(level.Rows))
+	}
+	for i, row := range level.Rows {
+		if len(row) != playfieldW {
+			return "", fmt.Errorf("%s: row %d needs %d chars, got %d", path, i, playfieldW, len(row))
 		}
 	}
+	return strings.Join(level.Rows, "\n"), nil
+}
 
-	// Now load screenshot
-	f, err := os.Open(screenshot)
+// levelFromBin reads a level from a binary file and returns it in text
+// form. The file has playfieldH rows of playfieldW bytes, top to bottom and
+// left to right. Every byte is a tile in the order of tiles.png (0 = heart,
+// ..., 9 = wall, 10 = background, 11 = empty, 12 = blocker), with bit 7 set
+// for frozen tiles.
+func levelFromBin(path string) (string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Can't open screenshot: %v", err)
-		os.Exit(1)
+		return "", err
 	}
-	defer f.Close()
-	img, _, err = image.Decode(f)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Can't load screenshot: %v", err)
-		os.Exit(1)
+	if len(data) != playfieldW*playfieldH {
+		return "", fmt.Errorf("%s: need %d bytes, got %d", path, playfieldW*playfieldH, len(data))
 	}
-	levelW := img.Bounds().Dx()
-	levelH := img.Bounds().Dy()
-	var levelPix = make([]int, levelW*levelH)
-	for y := 0; y < levelH; y++ {
-		for x := 0; x < levelW; x++ {
-			levelPix[y*levelW+x] = colToInt(img.At(x, y))
+	var sb strings.Builder
+	for i, b := range data {
+		t := tile(b & 0x7f)
+		if b&0x80 != 0 {
+			t |= tileFrozen
 		}
-	}
-
-	// Find top border
-	top := 0
-	for {
-		sum := 0
-		for x := 0; x < levelW; x++ {
-			sum += levelPix[top*levelW+x]
+		c, found := tileToChar[t]
+		if !found {
+			return "", fmt.Errorf("%s: invalid tile 0x%02x at (%d,%d)", path, b, i%playfieldW, i/playfieldW)
 		}
-		if sum != 0 {
-			break
+		sb.WriteRune(c)
+		if i%playfieldW == playfieldW-1 {
+			sb.WriteByte('\n')
 		}
-		top++
 	}
+	return sb.String(), nil
+}
 
-	// Find left border
-	left := 0
-	for {
-		sum := 0
-		for y := 0; y < levelH; y++ {
-			sum += levelPix[y*levelW+left]
-		}
-		if sum != 0 {
-			break
-		}
-		left++
-	}
+func encodeLevel(pf *playfield) string {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(pf.dumpStr()))
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
 
-	// Finally, we can read the tiles!
-	pf := playfield{}
-	pf.fill(tileBg)
-	for pfY := 0; pfY < playfieldH; pfY++ {
-		for pfX := 0; pfX < playfieldW; pfX++ {
-			tileFound := -1
-			for t := 0; tileFound < 0 && t < nofTiles; t++ {
-				tileMatch := true
-				for y2 := 2; tileMatch && y2 < tileH-2; y2++ { // 2 pix border, we might have the cursor in
-					for x2 := 2; tileMatch && x2 < tileW-2; x2++ {
-						if tilesPix[y2*tileLineW+t*tileW+x2] != levelPix[(top+pfY*tileH+y2)*levelW+left+pfX*tileW+x2] {
-							tileMatch = false
-						}
-					}
-				}
-				if tileMatch {
-					tileFound = t
-				}
-			}
-			if tileFound < 0 {
-				tileFound = int(tileBg)
-			}
-			pf.set(pfX, pfY, tile(tileFound))
-		}
+// colToInt returns 0 for black pixels, i.e. the border and the background
+// of tiles, and 1 for everything else. Pixels with no color channel
+// brighter than black count as black.
+func colToInt(c color.Color, black int) int {
+	r, g, b, _ := c.RGBA()
+	if max(r, g, b)>>8 <= uint32(black) {
+		return 0
 	}
+	return 1
+}
 
-	return &pf
+// ScreenshotDecodeError is returned if the screenshot is not a valid image.
+type ScreenshotDecodeError struct {
+	Path string
+	Err  error
+}
 
+func (e *ScreenshotDecodeError) Error() string {
+	return fmt.Sprintf("can't load screenshot %s: %v", e.Path, e.Err)
 }
 
-// ================================================
-// == DEQUE
-// ==
+func (e *ScreenshotDecodeError) Unwrap() error {
+	return e.Err
+}
 
-type deque_elem struct {
-	next *deque_elem
-	val  *playfield
+// PlayfieldNotFoundError is returned if there is no playfield in the
+// screenshot.
+type PlayfieldNotFoundError struct {
+	Reason string
 }
 
-type deque struct {
-	head *deque_elem
-	tail *deque_elem
-	sz   int
+func (e *PlayfieldNotFoundError) Error() string {
+	return "could not locate playfield in screenshot: " + e.Reason
 }
 
-func (d *deque) empty() bool {
-	return d.head == nil
+// MultipleBoardsError is returned if a screenshot contains more than one
+// board, and -board-index doesn't tell which one to read.
+type MultipleBoardsError struct {
+	Origins []image.Point
 }
 
-func (d *deque) pop() *playfield {
-	d.sz--
-	res := d.head.val
-	d.head = d.head.next
-	if d.head == nil {
-		d.tail = nil
+func (e *MultipleBoardsError) Error() string {
+	var strs []string
+	for i, o := range e.Origins {
+		strs = append(strs, fmt.Sprintf("%d at (%d,%d)", i+1, o.X, o.Y))
 	}
-	return res
+	return fmt.Sprintf("multiple boards found: %s", strings.Join(strs, ", "))
 }
 
-func (d *deque) push(pf *playfield) {
-	d.sz++
-	elem := &deque_elem{val: pf}
-	if d.head == nil {
-		// first elem
+// TileRecognitionError is returned if some cells of the playfield don't
+// look like any of the tiles, or like several tiles equally well. These
+// cells are read as background.
+type TileRecognitionError struct {
+	Cells []pos
+}
+
+func (e *TileRecognitionError) Error() string {
+	var strs []string
+	for _, p := range e.Cells {
+		strs = append(strs, fmt.Sprintf("(%d,%d)", p.x, p.y))
+	}
+	return fmt.Sprintf("%d cells not recognized: %s", len(e.Cells), strings.Join(strs, " "))
+}
+
+// parseLocked parses a list of cells as passed to -locked, e.g. "3,4 5,6".
+func parseLocked(s string) (*[playfieldH][playfieldW]bool, error) {
+	var res [playfieldH][playfieldW]bool
+	for _, str := range strings.Fields(s) {
+		var x, y int
+		if _, err := fmt.Sscanf(str, "%d,%d", &x, &y); err != nil {
+			return nil, fmt.Errorf("cells must be x,y: %w", err)
+		}
+		if x < 0 || x >= playfieldW || y < 0 || y >= playfieldH {
+			return nil, fmt.Errorf("cell %d,%d is outside of the playfield", x, y)
+		}
+		res[y][x] = true
+	}
+	return &res, nil
+}
+
+// parseCrop parses a "x,y,w,h" rectangle as passed to -crop.
+func parseCrop(s string) (image.Rectangle, error) {
+	var x, y, w, h int
+	if _, err := fmt.Sscanf(s, "%d,%d,%d,%d", &x, &y, &w, &h); err != nil {
+		return image.Rectangle{}, fmt.Errorf("crop must be x,y,w,h: %w", err)
+	}
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, fmt.Errorf("crop width and height must be positive")
+	}
+	return image.Rect(x, y, x+w, y+h), nil
+}
+
+// ScreenshotOptions tells ParseScreenshot how to read a screenshot.
+type ScreenshotOptions struct {
+	// Only look at this part of the screenshot, if not empty (see -crop)
+	Crop image.Rectangle
+	// Number of pixels per tile that may differ from the tileset (see
+	// -screenshot-tolerance)
+	Tolerance int
+	// The brightest a color channel can be for a pixel to count as black
+	// (see -screenshot-black)
+	Black int
+	// The board to read from screenshots with several boards, starting at
+	// 1 (see -board-index). 0 means that there must only be one.
+	Board int
+	// Also try the screenshot mirrored, upside down, and rotated (see
+	// -screenshot-flipped)
+	Flipped bool
+}
+
+// screenshotMargin is the number of pixels along the edges of a cell that
+// are ignored when recognizing tiles, as the cursor is drawn there.
+const screenshotMargin = 2
+
+// Minimum number of pixels in a cell's border that need to differ from the
+// reference tile to consider the cell as selected by the cursor.
+const cursorMinDiff = 16
+
+// playfieldFromScreenshot reads the level from a screenshot file, see
+// ParseScreenshot.
+func playfieldFromScreenshot(screenshot string, opts ScreenshotOptions) (*playfield, *pos, error) {
+	f, err := os.Open(screenshot)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open screenshot: %w", err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, nil, &ScreenshotDecodeError{Path: screenshot, Err: err}
+	}
+	return ParseScreenshot(img, opts)
+}
+
+// flipImage returns the crop area of img (all of it if crop is empty),
+// flipped horizontally and/or vertically.
+func flipImage(img image.Image, crop image.Rectangle, flipX, flipY bool) *image.RGBA {
+	area := img.Bounds()
+	if !crop.Empty() {
+		area = crop.Intersect(area)
+	}
+	res := image.NewRGBA(image.Rect(0, 0, area.Dx(), area.Dy()))
+	for y := 0; y < area.Dy(); y++ {
+		for x := 0; x < area.Dx(); x++ {
+			srcX, srcY := x, y
+			if flipX {
+				srcX = area.Dx() - 1 - x
+			}
+			if flipY {
+				srcY = area.Dy() - 1 - y
+			}
+			res.Set(x, y, img.At(area.Min.X+srcX, area.Min.Y+srcY))
+		}
+	}
+	return res
+}
+
+// parseFlippedScreenshot implements ScreenshotOptions.Flipped: it also
+// tries the screenshot mirrored, upside down, and rotated by 180 degrees,
+// and uses whichever recognizes the most tiles. The playfield returned is
+// always the level as the game has it, so the solution needs to be flipped
+// the same way to be used on the flipped display.
+func parseFlippedScreenshot(img image.Image, opts ScreenshotOptions) (*playfield, *pos, error) {
+	opts.Flipped = false
+	orientations := []struct {
+		name         string
+		flipX, flipY bool
+	}{
+		{"", false, false},
+		{"mirrored left to right", true, false},
+		{"upside down", false, true},
+		{"rotated by 180 degrees", true, true},
+	}
+	var bestPf *playfield
+	var bestCursor *pos
+	var bestErr error
+	bestName := ""
+	bestUnrecognized := 0
+	for _, o := range orientations {
+		var pf *playfield
+		var cursor *pos
+		var err error
+		if o.flipX || o.flipY {
+			flippedOpts := opts
+			flippedOpts.Crop = image.Rectangle{}
+			pf, cursor, err = ParseScreenshot(flipImage(img, opts.Crop, o.flipX, o.flipY), flippedOpts)
+		} else {
+			pf, cursor, err = ParseScreenshot(img, opts)
+		}
+		unrecognized := 0
+		var recErr *TileRecognitionError
+		if errors.As(err, &recErr) {
+			unrecognized = len(recErr.Cells)
+		} else if err != nil {
+			if bestPf == nil && bestErr == nil {
+				bestErr = err
+			}
+			continue
+		}
+		if bestPf == nil || unrecognized < bestUnrecognized {
+			bestPf, bestCursor, bestErr, bestName, bestUnrecognized = pf, cursor, err, o.name, unrecognized
+		}
+	}
+	if bestPf != nil && bestName != "" {
+		slog.Warn("Screenshot is flipped, the solution is for the level as the game has it", "flipped", bestName)
+	}
+	return bestPf, bestCursor, bestErr
+}
+
+// ParseScreenshot reads the level from a screenshot. Also returns the
+// position of the cursor, or nil if no cursor was found.
+//
+// On a *TileRecognitionError, the playfield and cursor are returned anyway,
+// with the unrecognized cells set to background.
+func ParseScreenshot(screenshot image.Image, opts ScreenshotOptions) (*playfield, *pos, error) {
+	if opts.Flipped {
+		return parseFlippedScreenshot(screenshot, opts)
+	}
+
+	// First, load the tiles for comparison
+	img := tilesImage()
+	tileLineW := nofTileSprites * tileW
+	var tilesPix = make([]int, tileLineW*tileH)
+	for y := 0; y < tileH; y++ {
+		for x := 0; x < tileLineW; x++ {
+			tilesPix[y*tileLineW+x] = colToInt(img.At(x, y), opts.Black)
+		}
+	}
+
+	// Now look at the screenshot
+	area := screenshot.Bounds()
+	if crop := opts.Crop; !crop.Empty() {
+		if !crop.In(area) {
+			return nil, nil, fmt.Errorf("crop area %v is outside of screenshot %v", crop, area)
+		}
+		area = crop
+	}
+	levelW := area.Dx()
+	levelH := area.Dy()
+	var levelPix = make([]int, levelW*levelH)
+	for y := 0; y < levelH; y++ {
+		for x := 0; x < levelW; x++ {
+			levelPix[y*levelW+x] = colToInt(screenshot.At(area.Min.X+x, area.Min.Y+y), opts.Black)
+		}
+	}
+
+	// Find top border
+	top := 0
+	for {
+		if top >= levelH {
+			return nil, nil, &PlayfieldNotFoundError{Reason: "image is empty"}
+		}
+		sum := 0
+		for x := 0; x < levelW; x++ {
+			sum += levelPix[top*levelW+x]
+		}
+		if sum != 0 {
+			break
+		}
+		top++
+	}
+
+	// Find left border
+	left := 0
+	for {
+		if left >= levelW {
+			return nil, nil, &PlayfieldNotFoundError{Reason: "image is empty"}
+		}
+		sum := 0
+		for y := 0; y < levelH; y++ {
+			sum += levelPix[y*levelW+left]
+		}
+		if sum != 0 {
+			break
+		}
+		left++
+	}
+	if top+playfieldH*tileH > levelH || left+playfieldW*tileW > levelW {
+		return nil, nil, &PlayfieldNotFoundError{Reason: fmt.Sprintf("no room for %dx%d tiles at (%d,%d)", playfieldW, playfieldH, left, top)}
+	}
+
+	// Finally, we can read the tiles!
+	origins := []image.Point{{left, top}}
+	pf, cursor, unrecognized := readBoard(tilesPix, levelPix, levelW, origins[0], opts.Tolerance)
+	origins = append(origins, findMoreBoards(tilesPix, levelPix, levelW, levelH, origins[0], opts.Tolerance)...)
+	if opts.Board > len(origins) {
+		return nil, nil, &PlayfieldNotFoundError{Reason: fmt.Sprintf("there are only %d boards, not %d", len(origins), opts.Board)}
+	}
+	if len(origins) > 1 && opts.Board == 0 {
+		var found []image.Point
+		for _, o := range origins {
+			found = append(found, o.Add(area.Min))
+		}
+		return nil, nil, &MultipleBoardsError{Origins: found}
+	}
+	if opts.Board > 1 {
+		pf, cursor, unrecognized = readBoard(tilesPix, levelPix, levelW, origins[opts.Board-1], opts.Tolerance)
+	}
+
+	if len(unrecognized) > 0 {
+		return pf, cursor, &TileRecognitionError{Cells: unrecognized}
+	}
+	return pf, cursor, nil
+}
+
+// findMoreBoards looks for further boards in a screenshot, after the one at
+// first, e.g. in split-screen screenshots. Other boards are found like the
+// first one, starting at the topmost non-black pixel row, but only count if
+// all their tiles are recognized, so that anything else on the screen isn't
+// taken for a board.
+func findMoreBoards(tilesPix, levelPix []int, levelW, levelH int, first image.Point, tolerance int) []image.Point {
+	boardW, boardH := playfieldW*tileW, playfieldH*tileH
+	// Black out every board found, so the next search doesn't find it again
+	pix := slices.Clone(levelPix)
+	blackOut := func(o image.Point) {
+		for y := o.Y; y < o.Y+boardH; y++ {
+			for x := o.X; x < o.X+boardW; x++ {
+				pix[y*levelW+x] = 0
+			}
+		}
+	}
+	blackOut(first)
+
+	var res []image.Point
+	for {
+		i := slices.IndexFunc(pix, func(c int) bool { return c != 0 })
+		if i < 0 || i/levelW+boardH > levelH {
+			return res
+		}
+		top := i / levelW
+		left := -1
+		for x := 0; x < levelW && left < 0; x++ {
+			for y := top; y < top+boardH; y++ {
+				if pix[y*levelW+x] != 0 {
+					left = x
+					break
+				}
+			}
+		}
+		if left+boardW > levelW {
+			return res
+		}
+		o := image.Point{left, top}
+		if _, _, unrecognized := readBoard(tilesPix, pix, levelW, o, tolerance); len(unrecognized) > 0 {
+			return res
+		}
+		res = append(res, o)
+		blackOut(o)
+	}
+}
+
+// readBoard reads the tiles of the board whose top left corner is at o in
+// the black and white pixels of a screenshot, allowing tolerance differing
+// pixels per tile. The cells in unrecognized are set to background.
+func readBoard(tilesPix, levelPix []int, levelW int, o image.Point, tolerance int) (res *playfield, cursor *pos, unrecognized []pos) {
+	tileLineW := nofTileSprites * tileW
+	pf := playfield{}
+	pf.fill(tileBg)
+	cursorDiff := 0
+	for pfY := 0; pfY < playfieldH; pfY++ {
+		for pfX := 0; pfX < playfieldW; pfX++ {
+			// Pick the tile with the fewest differing pixels. If several
+			// tiles are equally close, we can't tell which one it is.
+			tileFound := -1
+			bestDist := 0
+			ambiguous := false
+			for t := 0; t < nofTileSprites; t++ {
+				dist := 0
+				for y2 := screenshotMargin; y2 < tileH-screenshotMargin; y2++ { // we might have the cursor in the border
+					for x2 := screenshotMargin; x2 < tileW-screenshotMargin; x2++ {
+						if tilesPix[y2*tileLineW+t*tileW+x2] != levelPix[(o.Y+pfY*tileH+y2)*levelW+o.X+pfX*tileW+x2] {
+							dist++
+						}
+					}
+				}
+				if dist > tolerance {
+					continue
+				}
+				if tileFound < 0 || dist < bestDist {
+					tileFound, bestDist, ambiguous = t, dist, false
+				} else if dist == bestDist {
+					ambiguous = true
+				}
+			}
+			if tileFound < 0 || ambiguous {
+				unrecognized = append(unrecognized, pos{pfX, pfY})
+				tileFound = int(tileBg)
+			}
+			pf.set(pfX, pfY, tile(tileFound))
+
+			// The cursor is drawn in the border we ignored above, so check
+			// how much that differs from the tile we found.
+			diff := 0
+			for y2 := 0; y2 < tileH; y2++ {
+				for x2 := 0; x2 < tileW; x2++ {
+					if y2 >= screenshotMargin && y2 < tileH-screenshotMargin && x2 >= screenshotMargin && x2 < tileW-screenshotMargin {
+						continue
+					}
+					if tilesPix[y2*tileLineW+tileFound*tileW+x2] != levelPix[(o.Y+pfY*tileH+y2)*levelW+o.X+pfX*tileW+x2] {
+						diff++
+					}
+				}
+			}
+			if diff >= cursorMinDiff && diff > cursorDiff {
+				cursor = &pos{pfX, pfY}
+				cursorDiff = diff
+			}
+		}
+	}
+	return &pf, cursor, unrecognized
+}
+
+// ================================================
+// == DEQUE
+// ==
+
+type deque_elem struct {
+	next *deque_elem
+	val  *playfield
+}
+
+type deque struct {
+	head *deque_elem
+	tail *deque_elem
+	sz   int
+}
+
+func (d *deque) empty() bool {
+	return d.head == nil
+}
+
+func (d *deque) pop() *playfield {
+	d.sz--
+	res := d.head.val
+	d.head = d.head.next
+	if d.head == nil {
+		d.tail = nil
+	}
+	return res
+}
+
+func (d *deque) push(pf *playfield) {
+	d.sz++
+	elem := &deque_elem{val: pf}
+	if d.head == nil {
+		// first elem
 		d.head = elem
 		d.tail = elem
 	} else {
@@ -551,85 +1967,2018 @@ func (d *deque) size() int {
 	return d.sz
 }
 
-func (d *deque) dump() {
+func (d *deque) forEach(f func(pf *playfield)) {
+	for cur := d.head; cur != nil; cur = cur.next {
+		f(cur.val)
+	}
+}
+
+// dump prints the number of moves and remaining tiles of the first limit
+// elements.
+func (d *deque) dump(limit int) {
 	fmt.Print("Deque dump begin:\n")
 	cur := d.head
 	i := 0
-	for cur != nil {
-		fmt.Printf("Elem %3d: %v\n", i, cur)
+	for cur != nil && i < limit {
+		fmt.Printf("Elem %3d: %d moves, %d tiles left\n", i, len(cur.val.path), cur.val.heuristic())
 		i++
 		cur = cur.next
 	}
-	fmt.Print("Deque dump end\n")
+	fmt.Printf("Deque dump end (%d of %d elements)\n", i, d.sz)
 }
 
 // ================================================
-// == GRAPHICS HELPERS
+// == PRIORITY QUEUE
 // ==
 
-var (
-	//go:embed tiles.png
-	tilesData []byte
+type pqueue_elem struct {
+	val  *playfield
+	prio int
+	seq  int // insertion order, to break ties deterministically
+}
 
-	//go:embed font.png
-	fontData []byte
+type pqueue_heap []*pqueue_elem
 
-	fontTexture  *sdl.Texture
-	tilesTexture *sdl.Texture
-)
+func (h pqueue_heap) Len() int { return len(h) }
+func (h pqueue_heap) Less(i, j int) bool {
+	if h[i].prio != h[j].prio {
+		return h[i].prio < h[j].prio
+	}
+	return h[i].seq < h[j].seq
+}
+func (h pqueue_heap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pqueue_heap) Push(x interface{}) { *h = append(*h, x.(*pqueue_elem)) }
+func (h *pqueue_heap) Pop() interface{} {
+	old := *h
+	elem := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return elem
+}
 
-func loadTexture(r *sdl.Renderer, png []byte) *sdl.Texture {
-	data, _ := sdl.RWFromMem(png)
-	surfaceImg, err := img.LoadRW(data, true)
-	if err != nil {
-		panic(err)
+// pqueue pops the playfield with the lowest priority first. Priorities are
+// computed by prio when pushing.
+type pqueue struct {
+	h    pqueue_heap
+	prio func(pf *playfield) int
+	seq  int
+}
+
+func (q *pqueue) empty() bool {
+	return len(q.h) == 0
+}
+
+func (q *pqueue) pop() *playfield {
+	return heap.Pop(&q.h).(*pqueue_elem).val
+}
+
+func (q *pqueue) push(pf *playfield) {
+	q.seq++
+	heap.Push(&q.h, &pqueue_elem{val: pf, prio: q.prio(pf), seq: q.seq})
+}
+
+func (q *pqueue) size() int {
+	return len(q.h)
+}
+
+// forEach calls f for all playfields in the order they were pushed.
+func (q *pqueue) forEach(f func(pf *playfield)) {
+	elems := append(pqueue_heap(nil), q.h...)
+	sort.Slice(elems, func(i, j int) bool { return elems[i].seq < elems[j].seq })
+	for _, elem := range elems {
+		f(elem.val)
+	}
+}
+
+// ================================================
+// == BEAM
+// ==
+
+// beam is a breadth-first frontier that only keeps the k playfields with
+// the fewest tiles left of every depth. Playfields with the same number of
+// tiles left are kept in the order they were pushed.
+type beam struct {
+	k       int
+	cur     []*playfield         // depth that is being popped
+	layers  map[int][]*playfield // deeper playfields, by depth
+	dropped int                  // number of playfields thrown away
+}
+
+func newBeam(k int) *beam {
+	return &beam{k: k, layers: make(map[int][]*playfield)}
+}
+
+// trim keeps the best k playfields of pfs.
+func (b *beam) trim(pfs []*playfield) []*playfield {
+	if len(pfs) <= b.k {
+		return pfs
+	}
+	sort.SliceStable(pfs, func(i, j int) bool { return pfs[i].heuristic() < pfs[j].heuristic() })
+	b.dropped += len(pfs) - b.k
+	return pfs[:b.k]
+}
+
+func (b *beam) push(pf *playfield) {
+	depth := len(pf.path)
+	b.layers[depth] = append(b.layers[depth], pf)
+	if len(b.layers[depth]) > 2*b.k {
+		// Trim early to bound memory. Same result as trimming at the end,
+		// as trimming keeps the order.
+		b.layers[depth] = b.trim(b.layers[depth])
+	}
+}
+
+func (b *beam) pop() *playfield {
+	if len(b.cur) == 0 {
+		depth := -1
+		for d := range b.layers {
+			if depth < 0 || d < depth {
+				depth = d
+			}
+		}
+		b.cur = b.trim(b.layers[depth])
+		delete(b.layers, depth)
+	}
+	pf := b.cur[0]
+	b.cur = b.cur[1:]
+	return pf
+}
+
+func (b *beam) empty() bool {
+	return len(b.cur) == 0 && len(b.layers) == 0
+}
+
+func (b *beam) size() int {
+	cnt := len(b.cur)
+	for _, pfs := range b.layers {
+		cnt += len(pfs)
+	}
+	return cnt
+}
+
+func (b *beam) forEach(f func(pf *playfield)) {
+	for _, pf := range b.cur {
+		f(pf)
+	}
+	var depths []int
+	for d := range b.layers {
+		depths = append(depths, d)
+	}
+	sort.Ints(depths)
+	for _, d := range depths {
+		for _, pf := range b.layers[d] {
+			f(pf)
+		}
+	}
+}
+
+// ================================================
+// == SEEN SET
+// ==
+
+// Must be a power of 2
+const seenShards = 64
+
+// seenKeys are the ways seenSet can store boards (see -seen-key):
+//   - tiles: the board itself. Exact, but big.
+//   - zobrist: only the board's hash. Very small, but two boards with the
+//     same hash count as the same, so a solution might be missed. Can't be
+//     written to checkpoints, as the boards can't be restored from it.
+//   - compact: one byte per cell, without the border. Exact, and a lot
+//     smaller than tiles.
+var seenKeys = []string{"tiles", "zobrist", "compact"}
+
+// seenKey is the key newSeenSet uses.
+var seenKey = "compact"
+
+// compactTiles is a board with one byte per cell: the tile's base type, with
+// bit 7 set for frozen tiles.
+type compactTiles [playfieldH * playfieldW]byte
+
+func (t tiles) compact() compactTiles {
+	var c compactTiles
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			cell := t[y+1][x+1]
+			c[y*playfieldW+x] = byte(cell.base())
+			if cell.isFrozen() {
+				c[y*playfieldW+x] |= 0x80
+			}
+		}
+	}
+	return c
+}
+
+// tiles converts the board back, with background as the border.
+func (c compactTiles) tiles() tiles {
+	pf := playfield{}
+	pf.fill(tileBg)
+	for i, b := range c {
+		t := tile(b & 0x7f)
+		if b&0x80 != 0 {
+			t |= tileFrozen
+		}
+		pf.set(i%playfieldW, i/playfieldW, t)
+	}
+	return pf.tiles
+}
+
+// seenSet is a set of boards. If created for concurrent use, it is split
+// into shards by the board's hash, each with its own lock, so that
+// goroutines adding different boards rarely wait for each other. Otherwise,
+// everything goes into the first shard, without hashing or locking. Only one
+// of the maps is used, depending on the key.
+type seenSet struct {
+	key        string
+	concurrent bool
+	shards     [seenShards]struct {
+		sync.Mutex
+		byTiles   map[tiles]bool
+		byHash    map[uint64]bool
+		byCompact map[compactTiles]bool
+	}
+}
+
+func newSeenSet(concurrent bool) *seenSet {
+	s := &seenSet{key: seenKey, concurrent: concurrent}
+	for i := range s.shards {
+		switch s.key {
+		case "tiles":
+			s.shards[i].byTiles = make(map[tiles]bool)
+		case "zobrist":
+			s.shards[i].byHash = make(map[uint64]bool)
+		default:
+			s.shards[i].byCompact = make(map[compactTiles]bool)
+		}
 	}
-	textureImg, err := r.CreateTextureFromSurface(surfaceImg)
+	return s
+}
+
+// add adds t to the set, and returns whether it wasn't in the set before.
+func (s *seenSet) add(t tiles) bool {
+	var h uint64
+	if s.concurrent || s.key == "zobrist" {
+		h = t.hash()
+	}
+	shard := &s.shards[0]
+	if s.concurrent {
+		shard = &s.shards[h&(seenShards-1)]
+		shard.Lock()
+		defer shard.Unlock()
+	}
+	switch s.key {
+	case "tiles":
+		if shard.byTiles[t] {
+			return false
+		}
+		shard.byTiles[t] = true
+	case "zobrist":
+		if shard.byHash[h] {
+			return false
+		}
+		shard.byHash[h] = true
+	default:
+		c := t.compact()
+		if shard.byCompact[c] {
+			return false
+		}
+		shard.byCompact[c] = true
+	}
+	return true
+}
+
+// forEach calls f for all boards in the set. Not supported with the
+// zobrist key.
+func (s *seenSet) forEach(f func(t tiles)) {
+	if s.key == "zobrist" {
+		panic("can't list the boards of a seen set with zobrist keys")
+	}
+	for i := range s.shards {
+		s.shards[i].Lock()
+		for t := range s.shards[i].byTiles {
+			f(t)
+		}
+		for c := range s.shards[i].byCompact {
+			f(c.tiles())
+		}
+		s.shards[i].Unlock()
+	}
+}
+
+func (s *seenSet) size() int {
+	cnt := 0
+	for i := range s.shards {
+		s.shards[i].Lock()
+		cnt += len(s.shards[i].byTiles) + len(s.shards[i].byHash) + len(s.shards[i].byCompact)
+		s.shards[i].Unlock()
+	}
+	return cnt
+}
+
+// ================================================
+// == GRAPHICS HELPERS
+// ==
+
+var (
+	//go:embed tiles.png
+	tilesData []byte
+
+	//go:embed font.png
+	fontData []byte
+
+	fontTexture  *sdl.Texture
+	tilesTexture *sdl.Texture
+
+	tilesImg image.Image
+)
+
+func checkTilesetSize(img image.Image) error {
+	if b := img.Bounds(); b.Dx() != nofTileSprites*tileW || b.Dy() != tileH {
+		return fmt.Errorf("tileset must be %dx%d pixels, but is %dx%d", nofTileSprites*tileW, tileH, b.Dx(), b.Dy())
+	}
+	return nil
+}
+
+// checkTilesDistinct makes sure that no two tiles look the same to
+// ParseScreenshot with the given black threshold, i.e. in black and white
+// and without the margin. Otherwise, which one a screenshot's cell is read
+// as would depend on the order of the tiles.
+func checkTilesDistinct(img image.Image, black int) error {
+	for t1 := 0; t1 < nofTileSprites; t1++ {
+	nextTile:
+		for t2 := t1 + 1; t2 < nofTileSprites; t2++ {
+			for y := screenshotMargin; y < tileH-screenshotMargin; y++ {
+				for x := screenshotMargin; x < tileW-screenshotMargin; x++ {
+					if colToInt(img.At(t1*tileW+x, y), black) != colToInt(img.At(t2*tileW+x, y), black) {
+						continue nextTile
+					}
+				}
+			}
+			return fmt.Errorf("%s and %s can't be told apart in screenshots", tile(t1).name(), tile(t2).name())
+		}
+	}
+	return nil
+}
+
+func init() {
+	// Screenshot parsing and rendering rely on the layout, so make sure
+	// nobody swapped tiles.png for something else.
+	if err := checkTilesetSize(tilesImage()); err != nil {
+		panic(fmt.Sprintf("embedded tiles.png is broken: %v", err))
+	}
+	if err := checkTilesDistinct(tilesImage(), 0); err != nil {
+		panic(fmt.Sprintf("embedded tiles.png is broken: %v", err))
+	}
+}
+
+// loadTileset replaces the embedded tileset with the one from the given
+// PNG file, which needs to have the same layout: nofTileSprites tiles in a
+// single row. The tiles need to be distinct with the given black threshold.
+func loadTileset(path string, black int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err := checkTilesetSize(img); err != nil {
+		return err
+	}
+	if err := checkTilesDistinct(img, black); err != nil {
+		return err
+	}
+	tilesData = data
+	tilesImg = img
+	return nil
+}
+
+// tilesImage returns the decoded tileset, for everything that doesn't go through SDL.
+func tilesImage() image.Image {
+	if tilesImg == nil {
+		var err error
+		tilesImg, _, err = image.Decode(bytes.NewReader(tilesData))
+		if err != nil {
+			panic(err)
+		}
+	}
+	return tilesImg
+}
+
+// loadTexture creates a texture from an image file's contents. Broken
+// images are caught by Go's decoder, before SDL gets to see them.
+func loadTexture(r *sdl.Renderer, png []byte) (*sdl.Texture, error) {
+	if _, _, err := image.Decode(bytes.NewReader(png)); err != nil {
+		return nil, err
+	}
+	data, err := sdl.RWFromMem(png)
+	if err != nil {
+		return nil, err
+	}
+	surfaceImg, err := img.LoadRW(data, true)
+	if err != nil {
+		return nil, err
+	}
+	defer surfaceImg.Free()
+	return r.CreateTextureFromSurface(surfaceImg)
+}
+
+func loadImages(r *sdl.Renderer) {
+	var err error
+	tilesTexture, err = loadTexture(r, tilesData)
+	if err != nil {
+		panic(err)
+	}
+	// The font is only used for status text, so the viewer still works
+	// without it.
+	fontTexture, err = loadTexture(r, fontData)
+	if err != nil {
+		slog.Warn("Can't load font, not showing any text", "err", err)
+		fontTexture = nil
+	}
+}
+
+// updateZoom adapts zoom to the renderer's current output size, so that the
+// board fills a resized or fullscreen window.
+func updateZoom(r *sdl.Renderer) {
+	w, h, err := r.GetOutputSize()
+	if err != nil {
+		return
+	}
+	z := int(w) / (viewerBoards * playfieldW * tileW)
+	if zh := int(h) / (playfieldH * tileH); zh < z {
+		z = zh
+	}
+	if z < 1 {
+		z = 1
+	}
+	zoom = z
+}
+
+// setZoom changes the zoom factor, clamped to 1..10, and resizes the window
+// to fit.
+func setZoom(z int, w *sdl.Window) {
+	if z < 1 {
+		z = 1
+	} else if z > 10 {
+		z = 10
+	}
+	zoom = z
+	w.SetSize(int32(viewerBoards*playfieldW*tileW*zoom), int32(playfieldH*tileH*zoom))
+}
+
+func renderMove(m move, offsetX int, r *sdl.Renderer) {
+	r.SetDrawColor(highlightColor.R, highlightColor.G, highlightColor.B, highlightColor.A)
+	y := m.fromY*zoom*tileW + zoom*tileW/2
+	x := offsetX + m.fromX*zoom*tileH + zoom*tileH/2
+	r.FillRect(&sdl.Rect{X: int32(x - zoom*tileH/4), Y: int32(y - zoom*tileW/4), W: int32(zoom * tileW / 2), H: int32(zoom * tileH / 2)})
+
+	y = m.fromY*zoom*tileW + zoom*tileW/2
+	x = offsetX + m.toX*zoom*tileH + zoom*tileH/2
+	r.FillRect(&sdl.Rect{X: int32(x - zoom*tileH/4), Y: int32(y - zoom*tileW/4), W: int32(zoom * tileW / 2), H: int32(zoom * tileH / 2)})
+}
+
+// readScreen returns what the renderer shows right now.
+func readScreen(r *sdl.Renderer) (*image.RGBA, error) {
+	w, h, err := r.GetOutputSize()
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, int(w), int(h)))
+	// RGBA32 has the same byte order as image.RGBA, no matter the endianness
+	if err := r.ReadPixels(nil, sdl.PIXELFORMAT_RGBA32, unsafe.Pointer(&img.Pix[0]), img.Stride); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func savePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// renderHelpfulMove frames the tile to move and its destination.
+func renderHelpfulMove(m move, r *sdl.Renderer) {
+	r.SetDrawColor(highlightColor.R, highlightColor.G, highlightColor.B, highlightColor.A)
+	for _, x := range []int{m.fromX, m.toX} {
+		for i := 0; i < zoom; i++ {
+			r.DrawRect(&sdl.Rect{X: int32(x*zoom*tileW + i), Y: int32(m.fromY*zoom*tileH + i), W: int32(zoom*tileW - 2*i), H: int32(zoom*tileH - 2*i)})
+		}
+	}
+}
+
+// renderDiff frames the cells where pf differs from orig.
+func renderDiff(pf, orig *playfield, r *sdl.Renderer) {
+	r.SetDrawColor(255, 255, 0, 255)
+	for _, p := range diffTiles(pf.tiles, orig.tiles) {
+		for i := 0; i < zoom; i++ {
+			r.DrawRect(&sdl.Rect{X: int32(p.x*zoom*tileW + i), Y: int32(p.y*zoom*tileH + i), W: int32(zoom*tileW - 2*i), H: int32(zoom*tileH - 2*i)})
+		}
+	}
+}
+
+// renderTimeline draws a bar per move along the bottom of the board, as
+// high as the number of tiles the move cleared. The current move's bar is
+// highlighted.
+func renderTimeline(clears []int, idx int, r *sdl.Renderer) {
+	if len(clears) == 0 {
+		return
+	}
+	maxCleared := 1
+	for _, c := range clears {
+		maxCleared = max(maxCleared, c)
+	}
+	w := playfieldW * tileW * zoom / len(clears)
+	bottom := playfieldH * tileH * zoom
+	for i, c := range clears {
+		h := max(1, c*tileH*zoom/maxCleared)
+		if i == idx {
+			r.SetDrawColor(255, 255, 0, 255)
+		} else {
+			r.SetDrawColor(highlightColor.R, highlightColor.G, highlightColor.B, highlightColor.A)
+		}
+		r.FillRect(&sdl.Rect{X: int32(i * w), Y: int32(bottom - h), W: int32(max(1, w-1)), H: int32(h)})
+	}
+}
+
+// flashDuration is how long the viewer flashes cleared tiles.
+const flashDuration = 150 * time.Millisecond
+
+// renderFlash highlights the cells of tiles that are about to be cleared.
+func renderFlash(cells []pos, r *sdl.Renderer) {
+	r.SetDrawColor(255, 255, 255, 255)
+	for _, p := range cells {
+		r.FillRect(&sdl.Rect{X: int32(p.x * tileW * zoom), Y: int32(p.y * tileH * zoom), W: int32(tileW * zoom), H: int32(tileH * zoom)})
+	}
+}
+
+// renderRemaining frames the tiles that still need to be cleared.
+func renderRemaining(pf *playfield, r *sdl.Renderer) {
+	r.SetDrawColor(255, 0, 255, 255)
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			if !pf.get(x, y).base().isErasable() {
+				continue
+			}
+			for i := 0; i < zoom; i++ {
+				r.DrawRect(&sdl.Rect{X: int32(x*zoom*tileW + i), Y: int32(y*zoom*tileH + i), W: int32(zoom*tileW - 2*i), H: int32(zoom*tileH - 2*i)})
+			}
+		}
+	}
+}
+
+// renderMovePreview marks where the tile at p can be moved to, and tells
+// how many moves there are.
+func renderMovePreview(pf *playfield, p pos, r *sdl.Renderer) {
+	if !pf.get(p.x, p.y).isMobile() {
+		return
+	}
+	var targets []string
+	r.SetDrawBlendMode(sdl.BLENDMODE_BLEND)
+	r.SetDrawColor(255, 255, 255, 96)
+	for _, m := range pf.possibleMoves() {
+		if m.fromX != p.x || m.fromY != p.y {
+			continue
+		}
+		r.FillRect(&sdl.Rect{X: int32(m.toX * tileW * zoom), Y: int32(m.fromY * tileH * zoom), W: int32(tileW * zoom), H: int32(tileH * zoom)})
+		targets = append(targets, fmt.Sprint(m.toX))
+	}
+	r.SetDrawBlendMode(sdl.BLENDMODE_NONE)
+	if len(targets) == 0 {
+		text(0, 16*textZoom(), fmt.Sprintf("(%d,%d) can't move", p.x, p.y), r)
+	} else {
+		text(0, 16*textZoom(), fmt.Sprintf("(%d,%d) can move to x=%s", p.x, p.y, strings.Join(targets, ",")), r)
+	}
+}
+
+// renderLegend lists the tiles that occur in pf with their characters along
+// the right edge of the window, below the status texts.
+func renderLegend(pf *playfield, r *sdl.Renderer) {
+	var used []tile
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			if t := pf.get(x, y).base(); !slices.Contains(used, t) {
+				used = append(used, t)
+			}
+		}
+	}
+	slices.Sort(used)
+
+	w, _, err := r.GetOutputSize()
+	if err != nil {
+		return
+	}
+	tz := textZoom()
+	size := tileW * zoom
+	x := int(w) - size - 3*9*tz
+	y := 2 * 16 * tz
+	r.SetDrawColor(0, 0, 0, 255)
+	r.FillRect(&sdl.Rect{X: int32(x), Y: int32(y), W: int32(size + 3*9*tz), H: int32(len(used) * size)})
+	for i, t := range used {
+		renderSprite(t, &sdl.Rect{X: int32(x), Y: int32(y + i*size), W: int32(size), H: int32(size)}, r)
+		text(x+size+9*tz, y+i*size+(size-16*tz)/2, string(tileToChar[t]), r)
+	}
+}
+
+// textZoom is the zoom factor for text, which is a bit smaller than the
+// tiles' one.
+func textZoom() int {
+	if zoom-2 < 1 {
+		return 1
+	}
+	return zoom - 2
+}
+
+func text(x, y int, s string, r *sdl.Renderer) {
+	if fontTexture == nil {
+		return
+	}
+	tz := textZoom()
+	for _, c := range s {
+		cy := (c / 32) * 16
+		cx := (c % 32) * 9
+		srcRect := &sdl.Rect{X: int32(cx), Y: int32(cy), W: 9, H: 16}
+		dstRect := &sdl.Rect{X: int32(x), Y: int32(y), W: int32(9 * tz), H: int32(16 * tz)}
+		r.Copy(fontTexture, srcRect, dstRect)
+		x += 9 * tz
+	}
+}
+
+// ================================================
+// == HIGH CONTRAST TILES
+// ==
+
+type tilePattern int
+
+const (
+	patSolid tilePattern = iota
+	patHStripes
+	patVStripes
+	patChecker
+	patCross
+	patFrame
+	patDiagonal
+	patDot
+	patGrid
+)
+
+type tileStyle struct {
+	r, g, b uint8
+	pattern tilePattern
+}
+
+// Colors are from the Okabe-Ito palette, which works for most kinds of
+// color blindness. Patterns make the tiles distinguishable without color.
+var highContrastStyles = map[tile]tileStyle{
+	tile0:       {230, 159, 0, patSolid},
+	tile1:       {86, 180, 233, patHStripes},
+	tile2:       {0, 158, 115, patVStripes},
+	tile3:       {240, 228, 66, patChecker},
+	tile4:       {0, 114, 178, patCross},
+	tile5:       {213, 94, 0, patFrame},
+	tile6:       {204, 121, 167, patDiagonal},
+	tile7:       {255, 255, 255, patDot},
+	tile8:       {160, 160, 160, patGrid},
+	tileWall:    {90, 90, 90, patSolid},
+	tileBg:      {30, 30, 30, patSolid},
+	tileEmpty:   {0, 0, 0, patSolid},
+	tileBlocker: {255, 140, 0, patFrame},
+}
+
+// renderTileShape draws a tile with the high contrast palette instead of
+// the sprite from tiles.png.
+func renderTileShape(t tile, dst *sdl.Rect, r *sdl.Renderer) {
+	style := highContrastStyles[t]
+
+	// Draws a rect in tile pixel coordinates
+	fill := func(x, y, w, h int) {
+		r.FillRect(&sdl.Rect{X: dst.X + int32(x*zoom), Y: dst.Y + int32(y*zoom), W: int32(w * zoom), H: int32(h * zoom)})
+	}
+
+	r.SetDrawColor(0, 0, 0, 255)
+	r.FillRect(dst)
+	r.SetDrawColor(style.r, style.g, style.b, 255)
+	if !t.isMobile() && !t.isErasable() {
+		// Walls and friends are just plain blocks
+		fill(0, 0, tileW, tileH)
+		return
+	}
+
+	// 1 pixel black gap around mobile tiles, so that neighbours stay distinguishable
+	switch style.pattern {
+	case patSolid:
+		fill(1, 1, tileW-2, tileH-2)
+	case patHStripes:
+		for y := 1; y < tileH-1; y += 4 {
+			fill(1, y, tileW-2, 2)
+		}
+	case patVStripes:
+		for x := 1; x < tileW-1; x += 4 {
+			fill(x, 1, 2, tileH-2)
+		}
+	case patChecker:
+		for y := 1; y < tileH-1; y += 2 {
+			for x := 1 + (y/2%2)*2; x < tileW-1; x += 4 {
+				fill(x, y, 2, 2)
+			}
+		}
+	case patCross:
+		fill(tileW/2-2, 1, 4, tileH-2)
+		fill(1, tileH/2-2, tileW-2, 4)
+	case patFrame:
+		fill(1, 1, tileW-2, 3)
+		fill(1, tileH-4, tileW-2, 3)
+		fill(1, 1, 3, tileH-2)
+		fill(tileW-4, 1, 3, tileH-2)
+	case patDiagonal:
+		for i := 1; i < tileW-2; i++ {
+			fill(i, i, 2, 2)
+			fill(tileW-2-i, i, 2, 2)
+		}
+	case patDot:
+		fill(1, 1, tileW-2, tileH-2)
+		r.SetDrawColor(0, 0, 0, 255)
+		fill(tileW/2-3, tileH/2-3, 6, 6)
+	case patGrid:
+		for i := 1; i < tileW-1; i += 5 {
+			fill(i, 1, 1, tileH-2)
+			fill(1, i, tileW-2, 1)
+		}
+	}
+}
+
+// ================================================
+// == SOLVER
+// ==
+
+// frontier holds the playfields that still need to be expanded. The order
+// in which they're popped defines the search algorithm.
+type frontier interface {
+	push(pf *playfield)
+	pop() *playfield
+	empty() bool
+	size() int
+	forEach(f func(pf *playfield))
+}
+
+// histogram formats counts as "key:count" pairs, sorted by key.
+func histogram(counts map[int]int) string {
+	var keys []int
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	var parts []string
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%d:%d", k, counts[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// logQueueStats logs how the frontier's playfields are distributed over
+// search depth and number of remaining tiles.
+func logQueueStats(playfields frontier) {
+	byDepth := make(map[int]int)
+	byRemaining := make(map[int]int)
+	playfields.forEach(func(pf *playfield) {
+		byDepth[len(pf.path)]++
+		byRemaining[pf.heuristic()]++
+	})
+	slog.Debug("Queue stats", "size", playfields.size(), "byDepth", histogram(byDepth), "byRemaining", histogram(byRemaining))
+	if d, ok := playfields.(*deque); ok && *flagDumpDeque > 0 {
+		d.dump(*flagDumpDeque)
+	}
+}
+
+// algoNames are all the algorithms newFrontier knows.
+var algoNames = []string{"bfs", "greedy", "astar"}
+
+func newFrontier(algo string) frontier {
+	switch algo {
+	case "bfs":
+		if *flagBeam > 0 {
+			return newBeam(*flagBeam)
+		}
+		return &deque{}
+	case "greedy":
+		return &pqueue{prio: func(pf *playfield) int { return pf.heuristic() }}
+	case "astar":
+		h := (*playfield).heuristic
+		if *flagAstarBound {
+			h = (*playfield).lowerBoundMoves
+		}
+		// Priorities are ints, so keep 3 decimals of the weighted sum
+		return &pqueue{prio: func(pf *playfield) int {
+			return int(math.Round(1000 * (float64(len(pf.path)) + *flagWeight*float64(h(pf)))))
+		}}
+	}
+	return nil
+}
+
+// statsCSV receives the search statistics for -stats-csv, if not nil.
+var statsCSV *csv.Writer
+
+// searchStats tells how much work a search was.
+type searchStats struct {
+	Analyzed int // number of playfields expanded
+	Seen     int // number of distinct playfields generated
+	Duration time.Duration
+	// Heap in use at the end of the search, while the seen set and the
+	// frontier are still alive
+	HeapInUse uint64
+}
+
+// solve searches for a solution, using the given frontier. With a deque
+// (breadth-first search), the solution is guaranteed to be the shortest.
+// If no solution is found (because there is none, or because -max-moves or
+// -time-limit was hit), the playfield with the fewest tiles left is returned
+// instead, and solved is false.
+func solve(startPf *playfield, playfields frontier) (res *playfield, solved bool, stats searchStats) {
+	seen := newSeenSet(false)
+	if statsCSV != nil {
+		defer statsCSV.Flush()
+	}
+	if startPf.isSolved() {
+		// Nothing to do. Don't even look at moves, as they all lead to
+		// solved boards, too.
+		return startPf, true, stats
+	}
+	startPf = startPf.withBounds()
+
+	var solution *playfield
+	best := startPf
+	pfCnt := 0
+	if resumeFrom != nil {
+		best, pfCnt = resumeFrom.restore(playfields, seen, startPf)
+		slog.Info("Resuming search", "analyzed", pfCnt, "queue", playfields.size(), "seen", seen.size())
+	} else {
+		playfields.push(startPf)
+	}
+	bestRemaining := best.heuristic()
+
+	// With -optimize=moves, we keep going until all playfields on the
+	// solution's depth are expanded, and pick the solution that moves the
+	// tiles the least.
+	optimize := *flagOptimize == "moves"
+	candidates := 0
+	// With -optimize, the playfields queued for the depth being generated.
+	// Breadth-first search generates all of them before expanding any, so
+	// a cheaper path to one of them can still replace the queued one's.
+	var queued map[tiles]*playfield
+	queuedDepth := -1
+
+	// Without deduplication, the search is a tree, and only the cycle
+	// guard keeps it from going around in circles.
+	dedup := !*flagNoDedup
+	cycleGuard := *flagCycleGuard || !dedup
+
+	start := time.Now()
+	lastCheckpoint := start
+	for (solution == nil || optimize) && !playfields.empty() {
+		if pfCnt%1000 == 0 {
+			// Checked before popping, so that checkpoints contain
+			// everything that still needs to be looked at.
+			if *flagTimeLimit > 0 && time.Since(start) > *flagTimeLimit {
+				slog.Warn("Time limit reached, giving up", "limit", *flagTimeLimit)
+				if len(*flagCheckpoint) > 0 && solution == nil {
+					writeCheckpoint(*flagCheckpoint, startPf, playfields, seen, pfCnt, best)
+				}
+				break
+			}
+			if len(*flagCheckpoint) > 0 && solution == nil && time.Since(lastCheckpoint) > *flagCheckpointEvery {
+				writeCheckpoint(*flagCheckpoint, startPf, playfields, seen, pfCnt, best)
+				lastCheckpoint = time.Now()
+			}
+		}
+
+		pf := playfields.pop()
+		if solution != nil && len(pf.path)+1 > len(solution.path) {
+			// Successors can't be as short as the solution we have
+			break
+		}
+
+		pfCnt++
+		if pfCnt%100000 == 0 {
+			slog.Info("Searching", "analyzed", pfCnt, "queue", playfields.size())
+		}
+		if *flagQueueStats > 0 && pfCnt%*flagQueueStats == 0 {
+			logQueueStats(playfields)
+		}
+		if statsCSV != nil && pfCnt%*flagStatsEvery == 0 {
+			statsCSV.Write([]string{fmt.Sprint(pfCnt), fmt.Sprint(playfields.size()), fmt.Sprint(seen.size()), fmt.Sprint(time.Since(start).Milliseconds())})
+		}
+		if *flagMaxMoves > 0 && len(pf.path) >= *flagMaxMoves {
+			// Successors would need too many moves
+			continue
+		}
+
+		moves := pf.possibleMoves()
+		if *flagMaxBranching > 0 && len(moves) > *flagMaxBranching {
+			moves = pf.mostPromising(moves, *flagMaxBranching)
+		}
+		slog.Debug("Expanding playfield", "depth", len(pf.path), "moves", len(moves))
+		dupCnt, cycleCnt, unsolvableCnt, prunedCnt, enqueuedCnt := 0, 0, 0, 0, 0
+		for _, m := range moves {
+			var pf2 *playfield
+			if *flagPrune {
+				var events []Event
+				pf2, events = pf.applyWithEvents(m)
+				if !pf.isUseful(pf2, events) {
+					prunedCnt++
+					continue
+				}
+			} else {
+				pf2 = pf.apply(m)
+			}
+			if optimize && pf2.isSolved() {
+				// Different paths can lead to the same solved board, so
+				// look at all of them.
+				candidates++
+				if solution == nil || pf2.moveDistance() < solution.moveDistance() {
+					solution = pf2
+				}
+				continue
+			}
+			if cycleGuard && pf2.revisits(startPf) {
+				cycleCnt++
+				continue
+			}
+			if dedup && !seen.add(pf2.tiles) {
+				// already processed or in queue
+				if q := queued[pf2.tiles]; q != nil && pf2.moveDistance() < q.moveDistance() {
+					*q = *pf2
+				}
+				dupCnt++
+				continue
+			}
+
+			if !*flagNoSolvPrune && !pf2.isSolvable() {
+				// not solvable, ignore
+				unsolvableCnt++
+				continue
+			}
+
+			if pf2.isSolved() {
+				// WOOHOO!!!!!
+				solution = pf2
+			}
+			if remaining := pf2.heuristic(); remaining < bestRemaining {
+				best, bestRemaining = pf2, remaining
+			}
+
+			playfields.push(pf2)
+			enqueuedCnt++
+			if optimize {
+				if len(pf2.path) != queuedDepth {
+					queued, queuedDepth = make(map[tiles]*playfield), len(pf2.path)
+				}
+				queued[pf2.tiles] = pf2
+			}
+		}
+		if *flagExplain {
+			slog.Debug("Expanded playfield", "generated", len(moves), "seen", dupCnt, "cycles", cycleCnt, "unsolvable", unsolvableCnt, "pruned", prunedCnt, "enqueued", enqueuedCnt)
+		}
+	}
+	slog.Info("Search done", "analyzed", pfCnt)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats = searchStats{Analyzed: pfCnt, Seen: seen.size(), Duration: time.Since(start), HeapInUse: mem.HeapInuse}
+	if solution == nil {
+		return best, false, stats
+	}
+	if optimize {
+		slog.Info("Picked the solution that moves the tiles the least", "considered", candidates, "moves", len(solution.path), "distance", solution.moveDistance())
+	}
+	return solution, true, stats
+}
+
+// optimalAlgos are the algorithms that always find the shortest solution.
+var optimalAlgos = map[string]bool{"bfs": true}
+
+// compareAlgos solves the level with every algorithm, checks the solutions,
+// and prints how they did. Returns false if any solution is wrong.
+func compareAlgos(startPf *playfield) bool {
+	ok := true
+	shortest := -1
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Algorithm\tMoves\tAnalyzed\tSeen\tTime\t\n")
+	for _, algo := range algoNames {
+		res, solved, stats := solve(startPf, newFrontier(algo))
+		moves := "-"
+		if solved {
+			moves = fmt.Sprint(len(res.path))
+			if !replaySolves(startPf, res.path) {
+				fmt.Fprintf(os.Stderr, "%s: solution doesn't solve the level!\n", algo)
+				ok = false
+			}
+			if optimalAlgos[algo] {
+				if shortest >= 0 && shortest != len(res.path) {
+					fmt.Fprintf(os.Stderr, "%s: found %d moves, but another optimal algorithm found %d!\n", algo, len(res.path), shortest)
+					ok = false
+				}
+				shortest = len(res.path)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t\n", algo, moves, stats.Analyzed, stats.Seen, stats.Duration.Round(time.Millisecond))
+	}
+	w.Flush()
+	return ok
+}
+
+// compareSeenKeys solves the level with every seen key, and prints how fast
+// they were and how much memory the search used.
+func compareSeenKeys(startPf *playfield) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Key\tMoves\tSeen\tTime\tBoards/s\tHeap (MiB)\t\n")
+	for _, key := range seenKeys {
+		seenKey = key
+		runtime.GC()
+		res, solved, stats := solve(startPf, newFrontier(*flagAlgo))
+		moves := "-"
+		if solved {
+			moves = fmt.Sprint(len(res.path))
+		}
+		perSec := float64(stats.Analyzed) / stats.Duration.Seconds()
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%.0f\t%.1f\t\n", key, moves, stats.Seen, stats.Duration.Round(time.Millisecond), perSec, float64(stats.HeapInUse)/(1<<20))
+	}
+	w.Flush()
+}
+
+// estimateBudget is the number of boards estimate looks at.
+const estimateBudget = 50000
+
+// estimate does a breadth-first search for the first few moves, and
+// extrapolates the growth of the layers to guess how many boards a full
+// search needs to look at, and how long that takes. As more and more boards
+// have been seen before, the layers grow slower the deeper the search goes,
+// so the growth factor is assumed to keep shrinking like it did so far. This
+// is only a rough guess, but should get the order of magnitude right.
+func estimate(startPf *playfield) {
+	start := time.Now()
+	seen := map[tiles]bool{startPf.tiles: true}
+	layers := []int{1}
+	layer := []*playfield{startPf}
+	analyzed := 0
+	for analyzed+len(layer) <= estimateBudget && len(layer) > 0 {
+		var next []*playfield
+		for _, pf := range layer {
+			analyzed++
+			if pf.isSolved() {
+				fmt.Printf("Found a solution with %d moves after looking at %d boards.\n", len(pf.path), analyzed)
+				return
+			}
+			for _, m := range pf.possibleMoves() {
+				pf2 := pf.apply(m)
+				if seen[pf2.tiles] || (!*flagNoSolvPrune && !pf2.isSolvable()) {
+					continue
+				}
+				seen[pf2.tiles] = true
+				next = append(next, pf2)
+			}
+		}
+		layer = next
+		layers = append(layers, len(layer))
+	}
+	if len(layer) == 0 {
+		fmt.Printf("No solution: all %d reachable boards looked at.\n", analyzed)
+		return
+	}
+	elapsed := time.Since(start)
+
+	depth := len(layers) - 1
+	growth := func(d int) float64 {
+		if d < 1 || layers[d-1] == 0 {
+			return 1
+		}
+		return float64(layers[d]) / float64(layers[d-1])
+	}
+	branching := growth(depth)
+	shrink := min(1, branching/growth(depth-1))
+	// Levels usually need about one move per tile
+	moves := 0
+	for _, cnt := range startPf.tileCounts() {
+		moves += cnt
+	}
+	moves = max(depth, moves)
+	total := float64(analyzed + layers[depth])
+	cnt := float64(layers[depth])
+	for d, b := depth, branching; d < moves; d++ {
+		b = max(1, b*shrink)
+		cnt *= b
+		total += cnt
+	}
+	perBoard := elapsed / time.Duration(analyzed)
+	fmt.Printf("Boards after each move: %v\n", layers)
+	fmt.Printf("Growth factor after the last move: %.1f\n", branching)
+	fmt.Printf("Assuming a solution with about %d moves:\n", moves)
+	fmt.Printf("  about 1e%d boards to look at\n", int(math.Round(math.Log10(total))))
+	fmt.Printf("  about %s\n", time.Duration(min(total*float64(perBoard), math.MaxInt64)).Round(time.Second))
+}
+
+// enumerate prints up to limit boards that need exactly depth moves to be
+// reached from startPf, and are not obviously unsolvable. Returns the number
+// of boards printed.
+func enumerate(startPf *playfield, depth, limit int) int {
+	seen := map[tiles]bool{startPf.tiles: true}
+	layer := []*playfield{startPf}
+	for d := 0; d < depth && len(layer) > 0; d++ {
+		var next []*playfield
+		for _, pf := range layer {
+			if pf.isSolved() {
+				// Nothing to move on a solved board
+				continue
+			}
+			for _, m := range pf.possibleMoves() {
+				pf2 := pf.apply(m)
+				if seen[pf2.tiles] || (!*flagNoSolvPrune && !pf2.isSolvable()) {
+					continue
+				}
+				seen[pf2.tiles] = true
+				next = append(next, pf2)
+			}
+		}
+		layer = next
+	}
+	cnt := 0
+	for _, pf := range layer {
+		if cnt == limit {
+			slog.Warn("Too many boards, stopping", "limit", limit, "boards", len(layer))
+			break
+		}
+		if len(pf.deadTiles()) > goalRemaining {
+			continue
+		}
+		cnt++
+		fmt.Printf("=== Board %d: %s\n%s", cnt, formatMoves(pf.path), pf.dumpStr())
+	}
+	return cnt
+}
+
+// trimSolution drops the moves of the solution after the level is solved.
+// The search never adds such moves, but better safe than sorry.
+func trimSolution(startPf, solution *playfield) *playfield {
+	pf := startPf
+	for _, m := range solution.path {
+		if pf.isSolved() {
+			slog.Warn("Dropping moves after the level is solved", "moves", len(solution.path)-len(pf.path))
+			return pf
+		}
+		pf = pf.apply(m)
+	}
+	return solution
+}
+
+// checkReplay panics if applying the path of pf to startPf doesn't lead to
+// pf's board. Everything shown to the user is replayed from the path, so it
+// would be wrong otherwise.
+func checkReplay(startPf, pf *playfield) {
+	replayed := startPf
+	for _, m := range pf.path {
+		replayed = replayed.apply(m)
+	}
+	if !replayed.tiles.equal(pf.tiles) {
+		panic(fmt.Sprintf("replaying the solution leads to\n%sinstead of\n%s", replayed.dumpStr(), pf.dumpStr()))
+	}
+}
+
+// applyChecked works like apply, but returns an error instead of applying
+// moves that aren't possible on pf.
+func (pf *playfield) applyChecked(m move) (*playfield, error) {
+	if !pf.get(m.fromX, m.fromY).isMobile() || pf.isLocked(m.fromX, m.fromY) {
+		return nil, fmt.Errorf("(%d,%d)->(%d,%d): no tile at (%d,%d) that can be moved", m.fromX, m.fromY, m.toX, m.fromY, m.fromX, m.fromY)
+	}
+	for _, m2 := range pf.possibleMoves() {
+		if m == m2 {
+			return pf.apply(m), nil
+		}
+	}
+	return nil, fmt.Errorf("(%d,%d)->(%d,%d): the tile can't move there", m.fromX, m.fromY, m.toX, m.fromY)
+}
+
+// replaySolves tells whether applying moves to startPf solves it, and all
+// moves are legal.
+func replaySolves(startPf *playfield, moves []move) bool {
+	pf := startPf
+	for _, m := range moves {
+		var err error
+		if pf, err = pf.applyChecked(m); err != nil {
+			return false
+		}
+	}
+	return pf.isSolved()
+}
+
+// validateSolution checks that moves solve startPf without solving it
+// again, and prints OK, or FAIL and the first move that is illegal or not
+// needed anymore.
+func validateSolution(startPf *playfield, moves []move) bool {
+	pf := startPf
+	for i, m := range moves {
+		if pf.isSolved() {
+			fmt.Printf("FAIL: move %d (%d,%d)->(%d,%d) isn't needed, the level is already solved\n", i+1, m.fromX, m.fromY, m.toX, m.fromY)
+			return false
+		}
+		next, err := pf.applyChecked(m)
+		if err != nil {
+			fmt.Printf("FAIL: move %d %v\n", i+1, err)
+			return false
+		}
+		pf = next
+	}
+	if !pf.isSolved() {
+		fmt.Printf("FAIL: level isn't solved after %d moves, stuck with %s\n", len(moves), formatTileCounts(pf.remainingTiles()))
+		return false
+	}
+	fmt.Printf("OK: level solved with %d moves\n", len(moves))
+	return true
+}
+
+// readSolution reads a solution from a file, either in the notation of
+// formatMoves, or as written by -format=json.
+func readSolution(path string) ([]move, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return parseMoves(string(data))
+	}
+	var summary struct {
+		Solution string `json:"solution"`
+	}
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, err
+	}
+	return parseMoves(summary.Solution)
+}
+
+// ================================================
+// == CHECKPOINTS
+// ==
+
+// checkpoint is the state of a search, written with -checkpoint and read
+// with -resume. Only exported fields are written by gob, hence the
+// checkpointPf copies of the playfields.
+type checkpoint struct {
+	Algo  string
+	Start tiles
+	// The settings that change which playfields the search looks at
+	Connectivity  int
+	GoalTile      tile
+	GoalRemaining int
+	Beam          int
+	SeenKey       string
+	Frozen        bool
+	Locked        [playfieldH][playfieldW]bool
+
+	Analyzed int
+	Best     checkpointPf
+	// Frontier in the order it needs to be pushed to resume.
+	Frontier []checkpointPf
+	Seen     []tiles
+}
+
+type checkpointPf struct {
+	Tiles tiles
+	Path  [][3]int // fromX, fromY, toX
+}
+
+func toCheckpointPf(pf *playfield) checkpointPf {
+	res := checkpointPf{Tiles: pf.tiles}
+	for _, m := range pf.path {
+		res.Path = append(res.Path, [3]int{m.fromX, m.fromY, m.toX})
+	}
+	return res
+}
+
+func (c checkpointPf) playfield() *playfield {
+	pf := &playfield{tiles: c.Tiles}
+	for _, m := range c.Path {
+		pf.path = append(pf.path, move{fromX: m[0], fromY: m[1], toX: m[2]})
+	}
+	return pf
+}
+
+// newCheckpoint returns a checkpoint for startPf with the current settings,
+// but without any search state.
+func newCheckpoint(startPf *playfield) checkpoint {
+	c := checkpoint{
+		Algo:          *flagAlgo,
+		Start:         startPf.tiles,
+		Connectivity:  len(neighbors),
+		GoalTile:      goalTile,
+		GoalRemaining: goalRemaining,
+		Beam:          *flagBeam,
+		SeenKey:       seenKey,
+		Frozen:        *flagFrozen,
+	}
+	if startPf.locked != nil {
+		c.Locked = *startPf.locked
+	}
+	return c
+}
+
+// resumeFrom is the checkpoint solve starts from, if not nil.
+var resumeFrom *checkpoint
+
+// writeCheckpoint writes the search state to path. A temporary file is used,
+// so that an interrupted write doesn't destroy the previous checkpoint.
+// Failures are only logged, the search can go on without checkpoints.
+func writeCheckpoint(path string, startPf *playfield, playfields frontier, seen *seenSet, analyzed int, best *playfield) {
+	c := newCheckpoint(startPf)
+	c.Analyzed, c.Best = analyzed, toCheckpointPf(best)
+	playfields.forEach(func(pf *playfield) {
+		c.Frontier = append(c.Frontier, toCheckpointPf(pf))
+	})
+	seen.forEach(func(t tiles) {
+		c.Seen = append(c.Seen, t)
+	})
+
+	err := func() error {
+		f, err := os.Create(path + ".tmp")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w := gzip.NewWriter(f)
+		if err := gob.NewEncoder(w).Encode(&c); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		return os.Rename(path+".tmp", path)
+	}()
+	if err != nil {
+		slog.Error("Can't write checkpoint", "path", path, "err", err)
+		return
+	}
+	slog.Info("Wrote checkpoint", "path", path, "analyzed", analyzed, "queue", len(c.Frontier), "seen", len(c.Seen))
+}
+
+// readCheckpoint reads a checkpoint written by writeCheckpoint, and checks
+// that it belongs to startPf and the current settings.
+func readCheckpoint(path string, startPf *playfield) (*checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var c checkpoint
+	if err := gob.NewDecoder(r).Decode(&c); err != nil {
+		return nil, err
+	}
+	if c.Start != startPf.tiles {
+		return nil, errors.New("checkpoint is for a different level")
+	}
+	want := newCheckpoint(startPf)
+	for _, s := range []struct {
+		flag      string
+		got, want any
+	}{
+		{"algo", c.Algo, want.Algo},
+		{"connectivity", c.Connectivity, want.Connectivity},
+		{"goal-tile", c.GoalTile, want.GoalTile},
+		{"goal-remaining", c.GoalRemaining, want.GoalRemaining},
+		{"beam", c.Beam, want.Beam},
+		{"seen-key", c.SeenKey, want.SeenKey},
+		{"frozen", c.Frozen, want.Frozen},
+		{"locked", c.Locked, want.Locked},
+	} {
+		if s.got != s.want {
+			return nil, fmt.Errorf("checkpoint was written with a different -%s", s.flag)
+		}
+	}
+	return &c, nil
+}
+
+// restore fills playfields and seen from the checkpoint, and returns the
+// best playfield and the number of analyzed playfields. Locked cells and
+// bounds are not part of the checkpoint, and are taken from startPf.
+func (c *checkpoint) restore(playfields frontier, seen *seenSet, startPf *playfield) (*playfield, int) {
+	for _, cpf := range c.Frontier {
+		pf := cpf.playfield()
+		pf.locked, pf.bounds = startPf.locked, startPf.bounds
+		playfields.push(pf)
+	}
+	for _, t := range c.Seen {
+		seen.add(t)
+	}
+	best := c.Best.playfield()
+	best.locked, best.bounds = startPf.locked, startPf.bounds
+	return best, c.Analyzed
+}
+
+// countSolutions counts the distinct move sequences of minimal length that
+// solve the level. The search runs layer by layer, and every state keeps
+// track of how many shortest paths lead to it. Counts saturate at limit.
+// Returns the number of moves and the number of solutions (0 if unsolvable).
+func countSolutions(startPf *playfield, limit int) (int, int) {
+	type entry struct {
+		pf  *playfield
+		cnt int
+	}
+	if startPf.isSolved() {
+		// The empty solution is the only shortest one
+		return 0, 1
+	}
+	seen := map[tiles]bool{startPf.tiles: true}
+	layer := []*entry{{pf: startPf, cnt: 1}}
+	for depth := 1; len(layer) > 0; depth++ {
+		next := make(map[tiles]*entry)
+		var order []*entry
+		for _, e := range layer {
+			for _, m := range e.pf.possibleMoves() {
+				pf2 := e.pf.apply(m)
+				if seen[pf2.tiles] {
+					// reached on an earlier layer, so this path is not the shortest
+					continue
+				}
+				if !*flagNoSolvPrune && !pf2.isSolvable() {
+					continue
+				}
+				e2, found := next[pf2.tiles]
+				if !found {
+					e2 = &entry{pf: pf2}
+					next[pf2.tiles] = e2
+					order = append(order, e2)
+				}
+				e2.cnt += e.cnt
+				if e2.cnt > limit {
+					e2.cnt = limit
+				}
+			}
+		}
+
+		solutions := 0
+		for _, e := range order {
+			seen[e.pf.tiles] = true
+			if e.pf.isSolved() {
+				solutions += e.cnt
+				if solutions > limit {
+					solutions = limit
+				}
+			}
+		}
+		if solutions > 0 {
+			return depth, solutions
+		}
+		layer = order
+	}
+	return 0, 0
+}
+
+// ================================================
+// == LEVEL PACKS
+// ==
+
+// A level pack is a gzipped text file. The first line is the header
+// "PUPUPACK 1 <number of levels>", followed by one line per level: the
+// level's 144 chars, a tab, and the solution in move notation (see
+// formatMoves).
+
+const packMagic = "PUPUPACK"
+
+type packLevel struct {
+	pf    *playfield
+	moves []move
+}
+
+// formatMoves writes moves in the compact notation "fromX,fromY>toX",
+// separated by spaces.
+func formatMoves(moves []move) string {
+	var strs []string
+	for _, m := range moves {
+		strs = append(strs, fmt.Sprintf("%d,%d>%d", m.fromX, m.fromY, m.toX))
+	}
+	return strings.Join(strs, " ")
+}
+
+// parseMoves is the inverse of formatMoves.
+func parseMoves(s string) ([]move, error) {
+	var moves []move
+	for _, str := range strings.Fields(s) {
+		var m move
+		if _, err := fmt.Sscanf(str, "%d,%d>%d", &m.fromX, &m.fromY, &m.toX); err != nil {
+			return nil, fmt.Errorf("bad move %q: %w", str, err)
+		}
+		moves = append(moves, m)
+	}
+	return moves, nil
+}
+
+func writePack(path string, levels []packLevel) error {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	fmt.Fprintf(w, "%s 1 %d\n", packMagic, len(levels))
+	for _, l := range levels {
+		fmt.Fprintf(w, "%s\t%s\n", strings.ReplaceAll(l.pf.dumpStr(), "\n", ""), formatMoves(l.moves))
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func readPack(path string) ([]packLevel, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	var version, cnt int
+	if _, err := fmt.Sscanf(lines[0], packMagic+" %d %d", &version, &cnt); err != nil || version != 1 {
+		return nil, fmt.Errorf("not a level pack")
+	}
+	if len(lines)-1 != cnt {
+		return nil, fmt.Errorf("pack should have %d levels, but has %d", cnt, len(lines)-1)
+	}
+	var levels []packLevel
+	for i, line := range lines[1:] {
+		board, solution, _ := strings.Cut(line, "\t")
+		var rows []string
+		for y := 0; y+playfieldW <= len(board); y += playfieldW {
+			rows = append(rows, board[y:y+playfieldW])
+		}
+		pf, err := playfieldFromString(strings.Join(rows, "\n"))
+		if err != nil {
+			return nil, fmt.Errorf("level %d: %w", i+1, err)
+		}
+		moves, err := parseMoves(solution)
+		if err != nil {
+			return nil, fmt.Errorf("level %d: %w", i+1, err)
+		}
+		levels = append(levels, packLevel{pf, moves})
+	}
+	return levels, nil
+}
+
+// packLevels solves the levels in the given text files, and writes them with
+// their solutions to a pack.
+func packLevels(path string, files []string) error {
+	var levels []packLevel
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		pf, err := playfieldFromString(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		solution, solved, _ := solve(pf, newFrontier(*flagAlgo))
+		if !solved {
+			return fmt.Errorf("%s: no solution found", file)
+		}
+		solution = trimSolution(pf, solution)
+		slog.Info("Solved level", "file", file, "moves", len(solution.path))
+		levels = append(levels, packLevel{pf, solution.path})
+	}
+	return writePack(path, levels)
+}
+
+// verifyPack replays the solutions in the pack, and returns false if any of
+// them doesn't solve its level.
+func verifyPack(levels []packLevel) bool {
+	ok := true
+	for i, l := range levels {
+		if replaySolves(l.pf, l.moves) {
+			fmt.Printf("Level %d: OK (%d moves)\n", i+1, len(l.moves))
+		} else {
+			fmt.Printf("Level %d: solution doesn't solve the level\n", i+1)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// ================================================
+// == MOVE EXECUTION
+// ==
+
+// MoveExecutor sends moves to the game, e.g. by injecting key presses into
+// an emulator, so that the solver can play the real game.
+type MoveExecutor interface {
+	Execute(m move) error
+}
+
+// logExecutor doesn't talk to any game, it just logs the moves.
+type logExecutor struct{}
+
+func (logExecutor) Execute(m move) error {
+	slog.Info("Executing move", "from", fmt.Sprintf("(%d,%d)", m.fromX, m.fromY), "to", fmt.Sprintf("(%d,%d)", m.toX, m.fromY))
+	return nil
+}
+
+// executors are the MoveExecutors -execute knows.
+var executors = map[string]func() MoveExecutor{
+	"log": func() MoveExecutor { return logExecutor{} },
+}
+
+// executeSolution sends the moves to e one by one, waiting delay before
+// each, so that the game can keep up.
+func executeSolution(e MoveExecutor, moves []move, delay time.Duration) error {
+	for i, m := range moves {
+		time.Sleep(delay)
+		if err := e.Execute(m); err != nil {
+			return fmt.Errorf("move %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// ================================================
+// == MAIN
+// ==
+
+// openViewer initializes SDL and opens the viewer window.
+func openViewer() (*sdl.Window, *sdl.Renderer, error) {
+	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
+		return nil, nil, err
+	}
+
+	window, err := sdl.CreateWindow("Pupu64 Solver", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
+		int32(viewerBoards*playfieldW*tileW*zoom), int32(playfieldH*tileH*zoom), sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE)
+	if err != nil {
+		sdl.Quit()
+		return nil, nil, err
+	}
+
+	renderer, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED)
+	if err != nil {
+		window.Destroy()
+		sdl.Quit()
+		return nil, nil, fmt.Errorf("failed to create renderer: %w", err)
+	}
+	renderer.Clear()
+
+	loadImages(renderer)
+	return window, renderer, nil
+}
+
+// undoStack keeps the boards of -play, for undo and redo.
+type undoStack struct {
+	boards []*playfield
+	// Index of the current board in boards. Boards after it can be redone.
+	cur   int
+	limit int
+}
+
+func newUndoStack(startPf *playfield, limit int) *undoStack {
+	return &undoStack{boards: []*playfield{startPf}, limit: limit}
+}
+
+func (u *undoStack) current() *playfield {
+	return u.boards[u.cur]
+}
+
+// push makes pf the current board. The boards that could be redone are
+// dropped, and so is the oldest one if there are more than limit moves to
+// undo.
+func (u *undoStack) push(pf *playfield) {
+	u.boards = append(u.boards[:u.cur+1], pf)
+	if len(u.boards) > u.limit+1 {
+		u.boards = u.boards[len(u.boards)-u.limit-1:]
+	}
+	u.cur = len(u.boards) - 1
+}
+
+func (u *undoStack) undo() bool {
+	if u.cur == 0 {
+		return false
+	}
+	u.cur--
+	return true
+}
+
+func (u *undoStack) redo() bool {
+	if u.cur == len(u.boards)-1 {
+		return false
+	}
+	u.cur++
+	return true
+}
+
+// play lets the user solve the level: click a tile (or move the cursor with
+// the arrow keys and press space) to select it, and then click where it
+// should go.
+func play(startPf *playfield, window *sdl.Window, r *sdl.Renderer) {
+	history := newUndoStack(startPf, *flagUndoLimit)
+	cursor := pos{playfieldW / 2, playfieldH / 2}
+	var selected *pos
+
+	// choose selects the tile at p, or moves the selected tile there.
+	choose := func(p pos) {
+		pf := history.current()
+		if selected != nil && p.y == selected.y {
+			m := move{fromX: selected.x, fromY: selected.y, toX: p.x}
+			selected = nil
+			for _, m2 := range pf.possibleMoves() {
+				if m == m2 {
+					history.push(pf.apply(m))
+					return
+				}
+			}
+		}
+		selected = nil
+		for _, m := range pf.possibleMoves() {
+			if m.fromX == p.x && m.fromY == p.y {
+				selected = &p
+				return
+			}
+		}
+	}
+
+	window.SetTitle("Pupu64 Solver: Click a tile and then where it should go (or use the Crsr keys and Space), U to undo, Y to redo, Esc to deselect, +/- to zoom, Q to quit")
+	for running := true; running; {
+		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+			switch ev := event.(type) {
+			case *sdl.QuitEvent:
+				running = false
+			case *sdl.WindowEvent:
+				if ev.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
+					updateZoom(r)
+				}
+			case *sdl.MouseButtonEvent:
+				if ev.Type == sdl.MOUSEBUTTONDOWN && ev.Button == sdl.BUTTON_LEFT {
+					if x, y := int(ev.X)/(tileW*zoom), int(ev.Y)/(tileH*zoom); x < playfieldW && y < playfieldH {
+						cursor = pos{x, y}
+						choose(cursor)
+					}
+				}
+			case *sdl.KeyboardEvent:
+				if ev.Type != sdl.KEYDOWN {
+					break
+				}
+				switch ev.Keysym.Sym {
+				case 'q':
+					running = false
+				case 'u':
+					if history.undo() {
+						selected = nil
+					}
+				case 'y':
+					if history.redo() {
+						selected = nil
+					}
+				case sdl.K_ESCAPE:
+					selected = nil
+				case sdl.K_SPACE, sdl.K_RETURN:
+					choose(cursor)
+				case sdl.K_LEFT:
+					cursor.x = max(0, cursor.x-1)
+				case sdl.K_RIGHT:
+					cursor.x = min(playfieldW-1, cursor.x+1)
+				case sdl.K_UP:
+					cursor.y = max(0, cursor.y-1)
+				case sdl.K_DOWN:
+					cursor.y = min(playfieldH-1, cursor.y+1)
+				case sdl.K_PLUS, sdl.K_EQUALS, sdl.K_KP_PLUS:
+					setZoom(zoom+1, window)
+				case sdl.K_MINUS, sdl.K_KP_MINUS:
+					setZoom(zoom-1, window)
+				}
+			}
+		}
+
+		pf := history.current()
+		pf.render(r)
+		if selected != nil {
+			renderMovePreview(pf, *selected, r)
+		}
+		r.SetDrawColor(255, 255, 0, 255)
+		for i := 0; i < zoom; i++ {
+			r.DrawRect(&sdl.Rect{X: int32(cursor.x*zoom*tileW + i), Y: int32(cursor.y*zoom*tileH + i), W: int32(zoom*tileW - 2*i), H: int32(zoom*tileH - 2*i)})
+		}
+		moves := len(pf.path)
+		switch {
+		case pf.isSolved():
+			text(0, 0, fmt.Sprintf("SOLVED with %d moves!", moves), r)
+		case len(pf.possibleMoves()) == 0:
+			text(0, 0, fmt.Sprintf("Move %d: no moves left, press U to undo", moves), r)
+		case !pf.isSolvable():
+			text(0, 0, fmt.Sprintf("Move %d: can't be solved anymore, press U to undo", moves), r)
+		default:
+			text(0, 0, fmt.Sprintf("Move %d", moves), r)
+		}
+		r.Present()
+	}
+}
+
+func initLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*flagLogLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid log level %q.\n", *flagLogLevel)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if (*flagExplain || *flagQueueStats > 0) && level > slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if *flagLogJSON {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// mustParseLevel parses a level in text form, and exits with a description
+// of the level format if that fails.
+func mustParseLevel(text string) *playfield {
+	pf, err := playfieldFromString(text)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		badLevelData()
+	}
+	return pf
+}
+
+func reportDeadTiles(pf *playfield, dead []pos) {
+	fmt.Printf("Tiles that are alone in their walled-in area, and can never be cleared:\n")
+	for _, p := range dead {
+		fmt.Printf("  %s at (%d,%d)\n", pf.get(p.x, p.y).name(), p.x, p.y)
+	}
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	surfaceImg.Free()
-	return textureImg
+	defer f.Close()
+	runtime.GC() // up-to-date statistics
+	return pprof.WriteHeapProfile(f)
 }
 
-func loadImages(r *sdl.Renderer) {
-	tilesTexture = loadTexture(r, tilesData)
-	fontTexture = loadTexture(r, fontData)
+// formatTileCounts formats tile counts as returned by remainingTiles, e.g.
+// "2×Heart, 3×Ring".
+func formatTileCounts(cnts map[tile]int) string {
+	var strs []string
+	for t := tile0; t <= tileBlocker; t++ {
+		if cnts[t] > 0 {
+			strs = append(strs, fmt.Sprintf("%d×%s", cnts[t], t.name()))
+		}
+	}
+	return strings.Join(strs, ", ")
 }
 
-func renderMove(m move, r *sdl.Renderer) {
-	r.SetDrawColor(0, 255, 55, 255)
-	y := m.fromY*zoom*tileW + zoom*tileW/2
-	x := m.fromX*zoom*tileH + zoom*tileH/2
-	r.FillRect(&sdl.Rect{X: int32(x - zoom*tileH/4), Y: int32(y - zoom*tileW/4), W: int32(zoom * tileW / 2), H: int32(zoom * tileH / 2)})
+func validate(pf *playfield) bool {
+	// If we got a playfield, dimensions and characters are fine:
+	// playfieldFromString fails otherwise.
+	fmt.Printf("Dimensions OK (%dx%d)\n", playfieldW, playfieldH)
+	fmt.Printf("All characters valid\n")
 
-	y = m.fromY*zoom*tileW + zoom*tileW/2
-	x = m.toX*zoom*tileH + zoom*tileH/2
-	r.FillRect(&sdl.Rect{X: int32(x - zoom*tileH/4), Y: int32(y - zoom*tileW/4), W: int32(zoom * tileW / 2), H: int32(zoom * tileH / 2)})
+	cnts := make(map[tile]int)
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			t := pf.get(x, y)
+			if t.base().isMobile() || t.base().isErasable() {
+				cnts[t]++
+			}
+		}
+	}
+	fmt.Printf("Tile counts:\n")
+	for t := tile0; t <= tileBlocker; t++ {
+		if cnts[t] > 0 {
+			fmt.Printf("  '%c': %d\n", tileToChar[t], cnts[t])
+		}
+	}
+	for t := tile0; t <= tile7; t++ {
+		if cnts[t|tileFrozen] > 0 {
+			fmt.Printf("  '%c': %d\n", tileToChar[t|tileFrozen], cnts[t|tileFrozen])
+		}
+	}
+
+	// Levels should be closed by walls. Background works the same, but
+	// is most likely a typo.
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			t := pf.get(x, y)
+			if t != tileEmpty && !t.base().isMobile() && !t.base().isErasable() {
+				continue
+			}
+			for _, d := range neighbors4 {
+				if x+d.x >= 0 && x+d.x < playfieldW && y+d.y >= 0 && y+d.y < playfieldH && pf.get(x+d.x, y+d.y) == tileBg {
+					fmt.Printf("Warning: (%d,%d) is next to background instead of a wall\n", x, y)
+					break
+				}
+			}
+		}
+	}
+
+	dead := pf.deadTiles()
+	switch {
+	case !pf.isSolvable():
+		fmt.Printf("Solvable: no (a tile type occurs only once)\n")
+	case len(dead) > 0:
+		fmt.Printf("Solvable: no (tiles can't reach each other)\n")
+	default:
+		fmt.Printf("Solvable: yes\n")
+	}
+	if len(dead) > 0 {
+		reportDeadTiles(pf, dead)
+	}
+	return pf.isSolvable() && len(dead) == 0
 }
 
-func text(x, y int, s string, r *sdl.Renderer) {
-	textZoom := zoom - 2
-	if textZoom < 1 {
-		textZoom = 1
+// reportSolution prints the solution, or why there is none, for humans.
+func reportSolution(startPf, solution *playfield, solved bool) {
+	if !solved {
+		fmt.Printf("No solution found. WTF???\n")
+		fmt.Printf("Stuck with: %s\n", formatTileCounts(solution.remainingTiles()))
+		if dead := startPf.deadTiles(); len(dead) > 0 {
+			reportDeadTiles(startPf, dead)
+		}
+		fmt.Printf("Tile groups in the level:\n")
+		groups := startPf.groupReport()
+		for t := tile0; t <= tileBlocker; t++ {
+			if sizes, found := groups[t]; found {
+				var strs []string
+				for _, size := range sizes {
+					strs = append(strs, fmt.Sprint(size))
+				}
+				fmt.Printf("  %s: %s\n", t.name(), strings.Join(strs, ", "))
+			}
+		}
+		if len(solution.path) > 0 {
+			fmt.Printf("Best partial solution:\n")
+		}
+	} else if len(solution.path) == 0 {
+		fmt.Printf("Level is already solved, no moves needed.\n")
+	} else if goalRemaining > 0 {
+		fmt.Printf("Solution found, leaving at most %d tiles:\n", goalRemaining)
+	} else if goalTile != anyTile {
+		fmt.Printf("Solution found, clearing all %s tiles:\n", goalTile.name())
+	} else {
+		fmt.Printf("Solution found:\n")
 	}
-	for _, c := range s {
-		cy := (c / 32) * 16
-		cx := (c % 32) * 9
-		srcRect := &sdl.Rect{X: int32(cx), Y: int32(cy), W: 9, H: 16}
-		dstRect := &sdl.Rect{X: int32(x), Y: int32(y), W: int32(9 * textZoom), H: int32(16 * textZoom)}
-		r.Copy(fontTexture, srcRect, dstRect)
-		x += 9 * textZoom
+	for idx, m := range solution.path {
+		fmt.Printf("Step %d: (%d,%d)->(%d,%d)\n", idx+1, m.fromX, m.fromY, m.toX, m.fromY)
+	}
+	if solved && (goalTile != anyTile || goalRemaining > 0) {
+		if remaining := solution.remainingTiles(); len(remaining) > 0 {
+			total := 0
+			for _, cnt := range remaining {
+				total += cnt
+			}
+			fmt.Printf("Tiles left: %d (%s)\n", total, formatTileCounts(remaining))
+		}
 	}
 }
 
-// ================================================
-// == MAIN
-// ==
+// writeSummary writes the result of the search in a machine-readable
+// format, json or csv.
+func writeSummary(w io.Writer, format string, solution *playfield, solved bool, stats searchStats) error {
+	summary := struct {
+		Solved     bool   `json:"solved"`
+		Moves      int    `json:"moves"`
+		Analyzed   int    `json:"analyzed"`
+		Seen       int    `json:"seen"`
+		DurationMS int64  `json:"duration_ms"`
+		Solution   string `json:"solution"`
+	}{solved, len(solution.path), stats.Analyzed, stats.Seen, stats.Duration.Milliseconds(), formatMoves(solution.path)}
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return enc.Encode(summary)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"solved", "moves", "analyzed", "seen", "duration_ms", "solution"})
+	cw.Write([]string{fmt.Sprint(summary.Solved), fmt.Sprint(summary.Moves), fmt.Sprint(summary.Analyzed), fmt.Sprint(summary.Seen), fmt.Sprint(summary.DurationMS), summary.Solution})
+	cw.Flush()
+	return cw.Error()
+}
 
 func main() {
 	flag.Parse()
 
+	initLogging()
 	initTileMap()
 
 	var startPf *playfield
@@ -641,141 +3990,674 @@ func main() {
 		os.Exit(1)
 
 	}
-	if len(*flagScreenshot) == 0 && len(*flagLevelData) == 0 {
-		fmt.Fprintf(os.Stderr, "Either -level or -screenshot need to be set.\n")
+	if *flagPalette != "classic" && *flagPalette != "high-contrast" {
+		fmt.Fprintf(os.Stderr, "Palette must be classic or high-contrast.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if newFrontier(*flagAlgo) == nil {
+		fmt.Fprintf(os.Stderr, "Unknown search algorithm %q.\n", *flagAlgo)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if len(*flagSideBySide) > 0 && newFrontier(*flagSideBySide) == nil {
+		fmt.Fprintf(os.Stderr, "Unknown search algorithm %q.\n", *flagSideBySide)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *flagFormat != "human" && *flagFormat != "json" && *flagFormat != "csv" {
+		fmt.Fprintf(os.Stderr, "Format must be human, json, or csv.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if c, err := parseColor(*flagBgColor); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -bg-color value: %v\n", err)
+		os.Exit(1)
+	} else {
+		bgColor = c
+	}
+	if *flagShotBlack < 0 || *flagShotBlack > 255 {
+		fmt.Fprintf(os.Stderr, "-screenshot-black must be between 0 and 255.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *flagBoardIndex < 0 {
+		fmt.Fprintf(os.Stderr, "-board-index must not be negative.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if !slices.Contains(seenKeys, *flagSeenKey) {
+		fmt.Fprintf(os.Stderr, "Unknown -seen-key %q, must be one of %s.\n", *flagSeenKey, strings.Join(seenKeys, ", "))
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *flagSeenKey == "zobrist" && len(*flagCheckpoint) > 0 {
+		fmt.Fprintf(os.Stderr, "-checkpoint doesn't work with -seen-key=zobrist.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	seenKey = *flagSeenKey
+	if *flagNoDedup {
+		slog.Warn("-no-dedup is for analysis only, the search will look at the same boards over and over")
+	}
+	if _, found := executors[*flagExecute]; len(*flagExecute) > 0 && !found {
+		fmt.Fprintf(os.Stderr, "Unknown -execute %q.\n", *flagExecute)
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *flagExecuteDelay < 0 {
+		fmt.Fprintf(os.Stderr, "-execute-delay must not be negative.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *flagPlaybackMS <= 0 {
+		fmt.Fprintf(os.Stderr, "-playback-ms must be positive.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *flagWeight < 0 {
+		fmt.Fprintf(os.Stderr, "-weight must not be negative.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *flagBeam < 0 || *flagBeam > 0 && *flagAlgo != "bfs" {
+		fmt.Fprintf(os.Stderr, "-beam must be positive, and only works with -algo=bfs.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *flagOptimize != "" && (*flagOptimize != "moves" || *flagAlgo != "bfs") {
+		fmt.Fprintf(os.Stderr, "-optimize only supports \"moves\", and only with -algo=bfs.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	switch *flagConnectivity {
+	case 4:
+		neighbors = neighbors4
+	case 8:
+		neighbors = neighbors8
+	default:
+		fmt.Fprintf(os.Stderr, "Connectivity must be 4 or 8.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if len(*flagGoalTile) > 0 {
+		t, found := charToTile[[]rune(*flagGoalTile)[0]]
+		if !found || len([]rune(*flagGoalTile)) != 1 || !t.base().isErasable() {
+			fmt.Fprintf(os.Stderr, "-goal-tile must be the character of an erasable tile, e.g. H.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		goalTile = t.base()
+	}
+	if *flagGoalRemaining < 0 {
+		fmt.Fprintf(os.Stderr, "-goal-remaining must not be negative.\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+	goalRemaining = *flagGoalRemaining
+	if len(*flagTileset) > 0 {
+		if err := loadTileset(*flagTileset, *flagShotBlack); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't load tileset: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(*flagPack) > 0 {
+		if err := packLevels(*flagPack, flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't create level pack: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if len(*flagUnpack) > 0 || len(*flagVerifyPack) > 0 {
+		path := *flagUnpack + *flagVerifyPack
+		levels, err := readPack(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't read level pack: %v\n", err)
+			os.Exit(1)
+		}
+		if len(*flagVerifyPack) > 0 {
+			if !verifyPack(levels) {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		for i, l := range levels {
+			fmt.Printf("Level %d:\n%sSolution: %s\n\n", i+1, l.pf.dumpStr(), formatMoves(l.moves))
+		}
+		os.Exit(0)
+	}
+	if len(*flagScreenshot) == 0 && len(*flagLevelData) == 0 && len(*flagLevelB64) == 0 && len(*flagLevelJSON) == 0 && len(*flagLevelBin) == 0 {
+		fmt.Fprintf(os.Stderr, "Either -level, -level-base64, -level-json, -level-bin, or -screenshot need to be set.\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 	if len(*flagScreenshot) > 0 {
-		startPf = playfieldFromScreenshot(*flagScreenshot)
+		opts := ScreenshotOptions{Tolerance: *flagShotTolerance, Black: *flagShotBlack, Board: *flagBoardIndex, Flipped: *flagShotFlipped}
+		if len(*flagCrop) > 0 {
+			var err error
+			if opts.Crop, err = parseCrop(*flagCrop); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -crop value: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		var err error
+		var cursor *pos
+		startPf, cursor, err = playfieldFromScreenshot(*flagScreenshot, opts)
+		var recErr *TileRecognitionError
+		if errors.As(err, &recErr) {
+			slog.Warn("Some tiles were not recognized, using background instead", "cells", len(recErr.Cells), "err", err)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't read level from screenshot: %v\n", err)
+			os.Exit(1)
+		}
+		if cursor != nil {
+			slog.Info("Found cursor in screenshot", "x", cursor.x, "y", cursor.y)
+		}
+	} else if len(*flagLevelB64) > 0 {
+		startPf = mustParseLevel(decodeLevel(*flagLevelB64))
+	} else if len(*flagLevelJSON) > 0 {
+		levelText, err := levelFromJSON(*flagLevelJSON)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't read level: %v\n", err)
+			os.Exit(1)
+		}
+		startPf = mustParseLevel(levelText)
+	} else if len(*flagLevelBin) > 0 {
+		levelText, err := levelFromBin(*flagLevelBin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't read level: %v\n", err)
+			os.Exit(1)
+		}
+		startPf = mustParseLevel(levelText)
 	} else {
-		startPf = playfieldFromString(*flagLevelData)
+		startPf = mustParseLevel(*flagLevelData)
 	}
 
-	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
-		panic(err)
+	if len(*flagLocked) > 0 {
+		locked, err := parseLocked(*flagLocked)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -locked value: %v\n", err)
+			os.Exit(1)
+		}
+		startPf.locked = locked
 	}
-	defer sdl.Quit()
 
-	window, err := sdl.CreateWindow("Pupu64 Solver", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
-		int32(playfieldW*tileW*zoom), int32(playfieldH*tileH*zoom), sdl.WINDOW_SHOWN)
-	if err != nil {
-		panic(err)
+	if err := startPf.ignoreTiles(*flagIgnoreTiles); err != nil {
+		fmt.Fprintf(os.Stderr, "-ignore-tiles: %v\n", err)
+		os.Exit(1)
 	}
-	defer window.Destroy()
 
-	renderer, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to create renderer: %s\n", err)
-		os.Exit(3)
+	if *flagEncode {
+		fmt.Println(encodeLevel(startPf))
+		os.Exit(0)
 	}
-	defer renderer.Destroy()
-	renderer.Clear()
 
-	loadImages(renderer)
+	if len(*flagValidateSol) > 0 {
+		moves, err := readSolution(*flagValidateSol)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't read solution: %v\n", err)
+			os.Exit(1)
+		}
+		if !validateSolution(startPf, moves) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *flagValidate {
+		if !validate(startPf) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	seen := make(map[tiles]bool)
-	playfields := deque{}
+	if *flagCountSols {
+		moves, cnt := countSolutions(startPf, *flagCountLimit)
+		if cnt == 0 {
+			fmt.Printf("No solution found.\n")
+			os.Exit(1)
+		}
+		if cnt >= *flagCountLimit {
+			fmt.Printf("At least %d solutions with %d moves.\n", cnt, moves)
+		} else {
+			fmt.Printf("%d solutions with %d moves.\n", cnt, moves)
+		}
+		os.Exit(0)
+	}
 
-	startPf.render(renderer)
-	playfields.push(startPf)
+	if *flagCheckDead {
+		if dead := startPf.deadTiles(); len(dead) > goalRemaining {
+			reportDeadTiles(startPf, dead)
+			os.Exit(1)
+		}
+	}
 
-	var solution *playfield
+	if *flagEnumerate > 0 {
+		if enumerate(startPf, *flagEnumerate, *flagEnumerateLimit) == 0 {
+			fmt.Printf("No boards after %d moves.\n", *flagEnumerate)
+		}
+		os.Exit(0)
+	}
 
-	pfCnt := 0
-	for solution == nil && !playfields.empty() {
+	if *flagEstimate {
+		estimate(startPf)
+		os.Exit(0)
+	}
 
-		pf := playfields.pop()
+	if *flagCompareSeen {
+		compareSeenKeys(startPf)
+		os.Exit(0)
+	}
 
-		pfCnt++
-		if pfCnt%100000 == 0 {
-			fmt.Printf("%d playfields analysed, current queue size %d\n", pfCnt, playfields.size())
+	if *flagCompare {
+		if !compareAlgos(startPf) {
+			os.Exit(1)
 		}
+		os.Exit(0)
+	}
 
-		moves := pf.possibleMoves()
-		for _, m := range moves {
-			pf2 := pf.apply(m)
-			if _, found := seen[pf2.tiles]; found {
-				// already processed or in queue
-				continue
-			}
+	if len(*flagStatsCSV) > 0 {
+		if *flagStatsEvery < 1 {
+			fmt.Fprintf(os.Stderr, "-stats-every must be at least 1.\n")
+			os.Exit(1)
+		}
+		f, err := os.Create(*flagStatsCSV)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't create stats file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		statsCSV = csv.NewWriter(f)
+		statsCSV.Write([]string{"states", "frontier", "seen", "elapsed_ms"})
+	}
 
-			seen[pf2.tiles] = true
+	if len(*flagResume) > 0 {
+		c, err := readCheckpoint(*flagResume, startPf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't resume: %v\n", err)
+			os.Exit(1)
+		}
+		resumeFrom = c
+	}
 
-			if !pf2.isSolvable() {
-				// not solvable, ignore
-				continue
-			}
+	if len(*flagSideBySide) > 0 {
+		viewerBoards = 2
+	}
+	window, renderer, err := openViewer()
+	if err != nil {
+		if *flagForceGUI {
+			fmt.Fprintf(os.Stderr, "Can't open viewer: %s\n", err)
+			os.Exit(3)
+		}
+		slog.Warn("Can't open viewer, only printing the solution", "err", err)
+	} else {
+		defer sdl.Quit()
+		defer window.Destroy()
+		defer renderer.Destroy()
+		startPf.render(renderer)
+	}
 
-			if pf2.isSolved() {
-				// WOOHOO!!!!!
-				solution = pf2
-			}
+	if *flagPlay {
+		if *flagUndoLimit < 0 {
+			fmt.Fprintf(os.Stderr, "-undo-limit must not be negative.\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if renderer == nil {
+			fmt.Fprintf(os.Stderr, "Can't play without the viewer.\n")
+			os.Exit(3)
+		}
+		play(startPf, window, renderer)
+		return
+	}
 
-			playfields.push(pf2)
+	if len(*flagCPUProfile) > 0 {
+		f, err := os.Create(*flagCPUProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't create CPU profile: %v\n", err)
+			os.Exit(1)
+		}
+		// Closed when main returns, after StopCPUProfile below
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't start CPU profile: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	playfields := newFrontier(*flagAlgo)
+	solution, solved, stats := solve(startPf, playfields)
+	checkReplay(startPf, solution)
+	if solved {
+		solution = trimSolution(startPf, solution)
+	}
+	if len(*flagCPUProfile) > 0 {
+		pprof.StopCPUProfile()
+	}
+	if len(*flagMemProfile) > 0 {
+		if err := writeHeapProfile(*flagMemProfile); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't write memory profile: %v\n", err)
 		}
 	}
-	fmt.Printf("%d playfields analyzed.\n", pfCnt)
+	if b, ok := playfields.(*beam); ok && b.dropped > 0 && *flagFormat == "human" {
+		fmt.Printf("Beam search dropped %d boards, the solution might not be the shortest.\n", b.dropped)
+	}
 
-	solved := solution != nil
-	if solution == nil {
-		fmt.Printf("No solution found. WTF???\n")
-		solution = startPf
+	if *flagFormat == "human" {
+		reportSolution(startPf, solution, solved)
+		if *flagVerbose && stats.Seen > 0 {
+			fmt.Printf("Memory: %.1f MiB heap in use after the search, about %d bytes per board seen\n", float64(stats.HeapInUse)/(1<<20), stats.HeapInUse/uint64(stats.Seen))
+		}
 	} else {
-		fmt.Printf("Solution found:\n")
-		for idx, m := range solution.path {
-			fmt.Printf("Step %d: (%d,%d)->(%d,%d)\n", idx+1, m.fromX, m.fromY, m.toX, m.fromY)
+		if err := writeSummary(os.Stdout, *flagFormat, solution, solved, stats); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't write summary: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(*flagExecute) > 0 {
+		if !solved {
+			slog.Warn("No solution found, not executing anything")
+		} else if err := executeSolution(executors[*flagExecute](), solution.path, *flagExecuteDelay); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't execute solution: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// With -side-by-side, the solution of the other algorithm
+	var solution2 *playfield
+	solved2 := false
+	if len(*flagSideBySide) > 0 {
+		solution2, solved2, _ = solve(startPf, newFrontier(*flagSideBySide))
+		checkReplay(startPf, solution2)
+		if solved2 {
+			solution2 = trimSolution(startPf, solution2)
+		}
+		if *flagFormat == "human" {
+			if solved2 {
+				fmt.Printf("Solution found with -algo=%s:\n", *flagSideBySide)
+			} else {
+				fmt.Printf("No solution found with -algo=%s, best partial solution:\n", *flagSideBySide)
+			}
+			for idx, m := range solution2.path {
+				fmt.Printf("Step %d: (%d,%d)->(%d,%d)\n", idx+1, m.fromX, m.fromY, m.toX, m.fromY)
+			}
+		}
+	}
+
+	if len(*flagSaveShot) > 0 {
+		final := startPf
+		for _, m := range solution.path {
+			final = final.apply(m)
+		}
+		if err := savePNG(*flagSaveShot, final.toImage()); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't save screenshot: %v\n", err)
+			os.Exit(1)
 		}
 	}
 
+	if len(*flagDumpStates) > 0 {
+		if err := writeStates(*flagDumpStates, startPf, solution.path); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't write boards: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if len(*flagSVGDir) > 0 {
+		if err := writeSVGs(*flagSVGDir, startPf, solution.path); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't write SVGs: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if renderer == nil {
+		// No viewer
+		return
+	}
+
 	moves := solution.path
 	steps := []*playfield{startPf}
+	// physics[i] are the boards in between steps[i] and steps[i+1]
+	var physics [][]*playfield
+	// clears[i] is the number of tiles cleared by moves[i]
+	var clears []int
+	// flashes[i] are the board before the first clear of moves[i], and the
+	// cells cleared then. board is nil if the move doesn't clear anything.
+	type flash struct {
+		board *playfield
+		cells []pos
+	}
+	var flashes []flash
 	cur := startPf
 	// cur.dump()
 	// fmt.Println()
 	for _, m := range moves {
-		cur = cur.apply(m)
+		traced := cur.applyTraced(m)
+		physics = append(physics, traced)
+		_, events := cur.applyWithEvents(m)
+		cleared := 0
+		var fl flash
+		passes := 0 // number of passes before the current event
+		for i, ev := range events {
+			cleared += len(ev.Cells)
+			if ev.Kind == EventClear && ev.Pass == 1 {
+				fl.board = traced[passes-1]
+				fl.cells = append(fl.cells, ev.Cells...)
+			}
+			if i == len(events)-1 || events[i+1].Kind != ev.Kind || events[i+1].Pass != ev.Pass {
+				passes++
+			}
+		}
+		clears = append(clears, cleared)
+		flashes = append(flashes, fl)
+		cur = traced[len(traced)-1]
 		// cur.dump()
 		// fmt.Println()
 		steps = append(steps, cur)
 	}
 
+	var steps2 []*playfield
+	if solution2 != nil {
+		steps2 = []*playfield{startPf}
+		for _, m := range solution2.path {
+			steps2 = append(steps2, steps2[len(steps2)-1].apply(m))
+		}
+		// Both step in lockstep, so keep showing the end of the shorter one
+		for len(steps) < len(steps2) {
+			steps = append(steps, steps[len(steps)-1])
+		}
+	}
+
 	idx := 0
+	subIdx := 0 // 0: before the move, >0: physics[idx][subIdx-1]
 	running := true
-	window.SetTitle(fmt.Sprintf("Pupu64 Solver: Use Crsr-Left and Crsr-Right, Q to quit"))
+	fullscreen := false
+	showHelpful := false
+	showDiff := false
+	showLegend := false
+	playing := *flagAutoplay || *flagExitWhenSolved
+	lastStep := time.Now()
+	viewerStart := time.Now()
+	var flashUntil time.Time
+	// startFlash flashes the tiles cleared by the move that lead to the
+	// current step.
+	startFlash := func() {
+		if !*flagNoFlash && idx-1 < len(flashes) && flashes[idx-1].board != nil {
+			flashUntil = time.Now().Add(flashDuration)
+		}
+	}
+	var hover *pos                  // cell under the mouse
+	helpful := make(map[int][]move) // helpful moves per step, computed on demand
+	window.SetTitle(fmt.Sprintf("Pupu64 Solver: Use Crsr-Left and Crsr-Right, Crsr-Up and Crsr-Down for physics, P to play/pause, H for helpful moves, D to show changes, L for a legend, C to copy board, S to save a screenshot, +/- to zoom, F for fullscreen, Q to quit"))
 	for running {
 		// Handle all the events
 		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 			switch ev := event.(type) {
 			case *sdl.QuitEvent:
 				running = false
+			case *sdl.WindowEvent:
+				if ev.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
+					updateZoom(renderer)
+				}
+			case *sdl.MouseMotionEvent:
+				hover = nil
+				if x, y := int(ev.X)/(tileW*zoom), int(ev.Y)/(tileH*zoom); x < playfieldW && y < playfieldH {
+					hover = &pos{x, y}
+				}
+			case *sdl.MouseWheelEvent:
+				if !fullscreen && sdl.GetModState()&sdl.KMOD_CTRL != 0 {
+					if ev.Y > 0 {
+						setZoom(zoom+1, window)
+					} else if ev.Y < 0 {
+						setZoom(zoom-1, window)
+					}
+				}
 			case *sdl.KeyboardEvent:
 				if ev.Type == sdl.KEYDOWN {
 					switch ev.Keysym.Sym {
 					case 'q':
 						running = false
+					case 'f':
+						fullscreen = !fullscreen
+						var flags uint32
+						if fullscreen {
+							flags = sdl.WINDOW_FULLSCREEN_DESKTOP
+						}
+						window.SetFullscreen(flags)
+					case sdl.K_PLUS, sdl.K_EQUALS, sdl.K_KP_PLUS:
+						if !fullscreen {
+							setZoom(zoom+1, window)
+						}
+					case sdl.K_MINUS, sdl.K_KP_MINUS:
+						if !fullscreen {
+							setZoom(zoom-1, window)
+						}
+					case 'h':
+						showHelpful = !showHelpful
+					case 'd':
+						showDiff = !showDiff
+					case 'l':
+						showLegend = !showLegend
+					case 'p', sdl.K_SPACE:
+						playing = !playing
+						lastStep = time.Now()
+					case 's':
+						// Save what's on screen, minus the text of the next frame
+						path := fmt.Sprintf("pupusolver-step%d.png", idx+1)
+						img, err := readScreen(renderer)
+						if err == nil {
+							err = savePNG(path, img)
+						}
+						if err != nil {
+							slog.Error("Can't save screenshot", "err", err)
+						} else {
+							slog.Info("Saved screenshot", "path", path)
+						}
+					case 'c':
+						// Print and copy the current board, e.g. to continue by hand from here
+						board := steps[idx].dumpStr()
+						if subIdx > 0 {
+							board = physics[idx][subIdx-1].dumpStr()
+						}
+						fmt.Print(board)
+						sdl.SetClipboardText(board)
 					case sdl.K_RIGHT:
-						if idx < len(moves) {
+						if idx < len(steps)-1 {
 							idx++
+							subIdx = 0
+							startFlash()
 						}
 					case sdl.K_LEFT:
 						if idx > 0 {
 							idx--
+							subIdx = 0
+						}
+					case sdl.K_DOWN:
+						if idx < len(moves) && subIdx < len(physics[idx]) {
+							subIdx++
+						}
+					case sdl.K_UP:
+						if subIdx > 0 {
+							subIdx--
 						}
 					}
 				}
 			}
 		}
 
-		steps[idx].render(renderer)
-		if idx < len(moves) {
-			m := moves[idx]
-			renderMove(moves[idx], renderer)
-			text(0, 0, fmt.Sprintf("Step %d of %d: Move (%d,%d) to (%d,%d)", idx+1, len(steps), m.fromX, m.fromY, m.toX, m.fromY), renderer)
-		} else if solved {
-			text(0, 0, fmt.Sprintf("Step %d of %d: SOLVED!", idx+1, len(steps)), renderer)
+		if playing && time.Since(lastStep) >= time.Duration(*flagPlaybackMS)*time.Millisecond {
+			if idx < len(steps)-1 {
+				idx++
+				subIdx = 0
+				startFlash()
+			} else {
+				playing = false
+				if *flagExitWhenSolved {
+					running = false
+				}
+			}
+			lastStep = time.Now()
+		}
+		if *flagExitAfter > 0 && time.Since(viewerStart) >= *flagExitAfter {
+			running = false
+		}
+
+		if time.Now().Before(flashUntil) {
+			fl := flashes[idx-1]
+			fl.board.render(renderer)
+			renderFlash(fl.cells, renderer)
+			renderer.Present()
+			continue
+		}
+
+		if subIdx > 0 {
+			physics[idx][subIdx-1].render(renderer)
+			if showDiff {
+				renderDiff(physics[idx][subIdx-1], startPf, renderer)
+			}
+			text(0, 0, fmt.Sprintf("Step %d of %d: Physics %d of %d", idx+1, len(moves)+1, subIdx, len(physics[idx])), renderer)
 		} else {
-			text(0, 0, "NO SOLUTION FOUND!", renderer)
+			steps[idx].render(renderer)
+			if showDiff {
+				renderDiff(steps[idx], startPf, renderer)
+			}
+			if showHelpful {
+				if _, found := helpful[idx]; !found {
+					helpful[idx] = steps[idx].helpfulMoves()
+				}
+				for _, m := range helpful[idx] {
+					renderHelpfulMove(m, renderer)
+				}
+			}
+			if hover != nil {
+				renderMovePreview(steps[idx], *hover, renderer)
+			}
+			if idx < len(moves) {
+				m := moves[idx]
+				renderMove(moves[idx], 0, renderer)
+				text(0, 0, fmt.Sprintf("Step %d of %d: Move (%d,%d) to (%d,%d)", idx+1, len(moves)+1, m.fromX, m.fromY, m.toX, m.fromY), renderer)
+			} else if solved {
+				text(0, 0, fmt.Sprintf("Step %d of %d: SOLVED!", len(moves)+1, len(moves)+1), renderer)
+			} else {
+				renderRemaining(steps[idx], renderer)
+				text(0, 0, fmt.Sprintf("NO SOLUTION FOUND! Stuck with %d tiles", steps[idx].heuristic()), renderer)
+			}
+		}
+		renderTimeline(clears, idx, renderer)
+		if showLegend {
+			renderLegend(startPf, renderer)
+		}
+		if steps2 != nil {
+			offsetX := playfieldW * tileW * zoom
+			i := min(idx, len(steps2)-1)
+			steps2[i].renderAt(offsetX, renderer)
+			if i < len(solution2.path) {
+				m := solution2.path[i]
+				renderMove(m, offsetX, renderer)
+				text(offsetX, 0, fmt.Sprintf("%s: Step %d of %d: Move (%d,%d) to (%d,%d)", *flagSideBySide, i+1, len(steps2), m.fromX, m.fromY, m.toX, m.fromY), renderer)
+			} else if solved2 {
+				text(offsetX, 0, fmt.Sprintf("%s: Step %d of %d: SOLVED!", *flagSideBySide, i+1, len(steps2)), renderer)
+			} else {
+				text(offsetX, 0, fmt.Sprintf("%s: NO SOLUTION FOUND!", *flagSideBySide), renderer)
+			}
 		}
 		renderer.Present()
 	}
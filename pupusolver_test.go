@@ -0,0 +1,1828 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const level93 = `
+PPPPPPPPPPPP
+PPPPPPPPPPPP
+PPPPP##PPPPP
+PPPP#.R#PPPP
+PPP#..2R#PPP
+PP#...S2F#PP
+PP#...FS1#PP
+PPP#..1R#PPP
+PPPP#.F#PPPP
+PPPPP##PPPPP
+PPPPPPPPPPPP
+PPPPPPPPPPPP
+`
+
+const level95 = `
+PPPPPPPPPPPP
+PPPPPPPPPPPP
+PP#######PPP
+PP#HRT.D#PPP
+PP#THR.R#PPP
+PP#1##.H#PPP
+PP#D.D.##PPP
+PP####.#PPPP
+PPP##1.#PPPP
+PPPP###PPPPP
+PPPPPPPPPPPP
+PPPPPPPPPPPP
+`
+
+func TestMain(m *testing.M) {
+	flag.Set("frozen", "true")
+	initTileMap()
+	os.Exit(m.Run())
+}
+
+// board parses a level given as its first rows. The missing rows are
+// filled up with background.
+func board(t *testing.T, rows ...string) *playfield {
+	t.Helper()
+	for len(rows) < playfieldH {
+		rows = append(rows, strings.Repeat("P", playfieldW))
+	}
+	pf, err := playfieldFromString(strings.Join(rows, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pf
+}
+
+func TestSolveAnalyzedBound(t *testing.T) {
+	// Upper bounds on the boards looked at, with some room to spare. If an
+	// optimization lowers the numbers, lower the bounds as well.
+	tests := []struct {
+		name  string
+		level string
+		moves int
+		bound int
+	}{
+		{"level 93", level93, 15, 2000},
+		{"level 95", level95, 14, 25000},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pf, err := playfieldFromString(tc.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, solved, stats := solve(pf, newFrontier("bfs"))
+			if !solved || len(res.path) != tc.moves {
+				t.Fatalf("got solved=%v with %d moves, want %d moves", solved, len(res.path), tc.moves)
+			}
+			if stats.Analyzed > tc.bound {
+				t.Errorf("looked at %d boards, want at most %d", stats.Analyzed, tc.bound)
+			}
+		})
+	}
+}
+
+// randomBoard returns a board with random cells, including some frozen
+// tiles and blockers. Tiles may float, they settle with the first move.
+func randomBoard(r *rand.Rand) *playfield {
+	var pf playfield
+	pf.fill(tileBg)
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			var t tile
+			switch k := r.Intn(20); {
+			case k < 8:
+				t = tileEmpty
+			case k < 10:
+				t = tileWall
+			case k < 11:
+				t = tileBg
+			case k < 12:
+				t = tileBlocker
+			case k < 13:
+				t = tile(r.Intn(8)) | tileFrozen
+			default:
+				t = tile(r.Intn(9))
+			}
+			pf.set(x, y, t)
+		}
+	}
+	return &pf
+}
+
+// scanCounts counts the erasable tiles per type without using the cache.
+func scanCounts(pf *playfield) [tileBlocker + 1]int {
+	var cnts [tileBlocker + 1]int
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			if t := pf.get(x, y).base(); t.isErasable() {
+				cnts[t]++
+			}
+		}
+	}
+	return cnts
+}
+
+func TestApplyInvariants(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		pf := randomBoard(r)
+		for step := 0; step < 20; step++ {
+			moves := pf.possibleMoves()
+			if len(moves) == 0 {
+				break
+			}
+			m := moves[r.Intn(len(moves))]
+			pf2 := pf.apply(m)
+
+			if pf2.tiles[0] != pf.tiles[0] || pf2.tiles[playfieldH+1] != pf.tiles[playfieldH+1] {
+				t.Fatalf("move %v changed the border of\n%s", m, pf.dumpStr())
+			}
+			for y := range pf.tiles {
+				if pf2.tiles[y][0] != pf.tiles[y][0] || pf2.tiles[y][playfieldW+1] != pf.tiles[y][playfieldW+1] {
+					t.Fatalf("move %v changed the border of\n%s", m, pf.dumpStr())
+				}
+			}
+			for y := 0; y < playfieldH; y++ {
+				for x := 0; x < playfieldW; x++ {
+					if (pf.get(x, y) == tileWall) != (pf2.get(x, y) == tileWall) {
+						t.Fatalf("move %v changed the wall at (%d,%d) of\n%s", m, x, y, pf.dumpStr())
+					}
+					if pf2.get(x, y).canFall() && pf2.get(x, y+1) == tileEmpty {
+						t.Fatalf("move %v leaves the tile at (%d,%d) floating:\n%s", m, x, y, pf2.dumpStr())
+					}
+				}
+			}
+			before, after := scanCounts(pf), scanCounts(pf2)
+			for tt := range after {
+				if after[tt] > before[tt] {
+					t.Fatalf("move %v increased the number of %ss from %d to %d:\n%s", m, tile(tt).name(), before[tt], after[tt], pf.dumpStr())
+				}
+			}
+			pf = pf2
+		}
+	}
+}
+
+// TestPlayfieldEdges covers the two bugs TestApplyInvariants found: fill
+// left the bottom and right border as hearts, which could be cleared along
+// with the hearts next to them, and tiles in the top row never fell.
+func TestPlayfieldEdges(t *testing.T) {
+	pf := board(t,
+		"#D.........#",
+		"#..........#",
+		"############",
+	)
+	pf.dropTiles()
+	if pf.get(1, 0) != tileEmpty || pf.get(1, 1) != tile1 {
+		t.Errorf("tile in the top row didn't fall:\n%s", pf.dumpStr())
+	}
+
+	pf = mustParseLevel(strings.Repeat("PPPPPPPPPPPP\n", playfieldH-2) + "#..........#\nH.H.........\n")
+	pf2 := pf.apply(move{fromX: 0, fromY: playfieldH - 1, toX: 1})
+	if pf2.tiles[playfieldH+1] != pf.tiles[playfieldH+1] {
+		t.Errorf("clearing hearts in the bottom row changed the border")
+	}
+	if got := pf2.tileCounts()[tile0]; got != 0 {
+		t.Errorf("got %d hearts left, want 0:\n%s", got, pf2.dumpStr())
+	}
+}
+
+func FuzzPlayfieldFromString(f *testing.F) {
+	f.Add(level93)
+	f.Add(level95)
+	f.Add(strings.Repeat("PPPPPPPPPPPP\n", 11))                       // too few lines
+	f.Add(strings.Repeat("PPPPPPPPPPPP\n", 13))                       // too many lines
+	f.Add(strings.Replace(level95, "PP#HRT.D#PPP", "PP#HRT.D#PP", 1)) // too short
+	f.Add(strings.Replace(level95, "PP#HRT.D#PPP", "PP#HRT.D#PPPP", 1))
+	f.Add(strings.Replace(level95, "PP#HRT.D#PPP", "PP#HRX.D#PPP", 1)) // bad char
+	f.Add(strings.Replace(level95, "PP#HRT.D#PPP", "PP#HRT.D#PPä", 1))
+	f.Add("")
+	f.Fuzz(func(t *testing.T, s string) {
+		pf, err := playfieldFromString(s)
+		if err != nil {
+			if pf != nil {
+				t.Fatalf("got a playfield and error %v", err)
+			}
+			return
+		}
+		for y := -1; y <= playfieldH; y++ {
+			for x := -1; x <= playfieldW; x++ {
+				tt := pf.get(x, y)
+				if x < 0 || y < 0 || x == playfieldW || y == playfieldH {
+					if tt != tileBg {
+						t.Fatalf("border at (%d,%d) is %v, not background", x, y, tt)
+					}
+				} else if _, found := tileToChar[tt]; !found {
+					t.Fatalf("unknown tile %v at (%d,%d)", tt, x, y)
+				}
+			}
+		}
+	})
+}
+
+func TestPossibleMoves(t *testing.T) {
+	tests := []struct {
+		name  string
+		rows  []string
+		moves []move
+	}{
+		{
+			// Slides along the ledge, and can go as far as the gap, where it
+			// falls down
+			name: "ledge with gap",
+			rows: []string{
+				"PPPPPPPPPPPP",
+				"P#H...#PPPPP",
+				"P####.#PPPPP",
+				"P######PPPPP",
+			},
+			moves: []move{{1, 2, 3}, {1, 2, 4}, {1, 2, 5}},
+		},
+		{
+			// Stops on top of the other heart, as both get cleared there
+			name: "same tile below",
+			rows: []string{
+				"PPPPPPPPPPPP",
+				"P#H...#PPPPP",
+				"P###H.#PPPPP",
+				"P######PPPPP",
+			},
+			moves: []move{{1, 2, 3}, {1, 2, 4}, {2, 4, 5}},
+		},
+		{
+			// Glassblocks are never cleared, so they slide over each other
+			name: "glassblock over glassblock",
+			rows: []string{
+				"PPPPPPPPPPPP",
+				"P#G...#PPPPP",
+				"P###G.#PPPPP",
+				"P######PPPPP",
+			},
+			moves: []move{{1, 2, 3}, {1, 2, 4}, {1, 2, 5}, {2, 4, 5}},
+		},
+		{
+			name: "walled in",
+			rows: []string{
+				"PPPPPPPPPPPP",
+				"P#H#PPPPPPPP",
+				"P###PPPPPPPP",
+			},
+			moves: nil,
+		},
+		{
+			// Only the empty side can be moved to
+			name: "wall on one side",
+			rows: []string{
+				"PPPPPPPPPPPP",
+				"P#H.#PPPPPPP",
+				"P####PPPPPPP",
+			},
+			moves: []move{{1, 2, 3}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			pf := board(t, tc.rows...)
+			if got := pf.possibleMoves(); !slices.Equal(got, tc.moves) {
+				t.Errorf("got moves %v, want %v", got, tc.moves)
+			}
+		})
+	}
+}
+
+func TestSolveRespectsIrregularWalls(t *testing.T) {
+	// Level 93 has a diamond shaped frame, so the rows have different widths
+	pf, err := playfieldFromString(level93)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, solved, _ := solve(pf, newFrontier("bfs"))
+	if !solved {
+		t.Fatal("level 93 not solved")
+	}
+	cur := pf
+	for i, m := range res.path {
+		from, to := min(m.fromX, m.toX), max(m.fromX, m.toX)
+		for x := from; x <= to; x++ {
+			if x != m.fromX && cur.get(x, m.fromY) != tileEmpty {
+				t.Fatalf("step %d %v moves into or through %s at (%d,%d):\n%s", i+1, m, cur.get(x, m.fromY).name(), x, m.fromY, cur.dumpStr())
+			}
+		}
+		next := cur.apply(m)
+		for y := 0; y < playfieldH; y++ {
+			for x := 0; x < playfieldW; x++ {
+				if (cur.get(x, y) == tileWall) != (next.get(x, y) == tileWall) {
+					t.Fatalf("step %d %v changes the wall at (%d,%d)", i+1, m, x, y)
+				}
+			}
+		}
+		cur = next
+	}
+}
+
+func TestPossibleMovesStayOnBoard(t *testing.T) {
+	boards := map[string]*playfield{
+		"edges of the board": board(t,
+			"H..........D",
+			"############",
+		),
+		"flush against walls": board(t,
+			"PPPPPPPPPPPP",
+			"#H........D#",
+			"############",
+		),
+		"interior walls": board(t,
+			"PPPPPPPPPPPP",
+			"#..H#D..T#R#",
+			"#.#..#.S##.#",
+			"############",
+		),
+		"background around": board(t,
+			"PPPPPPPPPPPP",
+			"PH..P..DPPPP",
+			"PPPPPPPPPPPP",
+		),
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		boards[fmt.Sprintf("random %d", i)] = randomBoard(r)
+	}
+	for name, pf := range boards {
+		for _, pf := range []*playfield{pf, pf.withBounds()} {
+			for _, m := range pf.possibleMoves() {
+				if m.toX < 0 || m.toX >= playfieldW {
+					t.Errorf("%s: move %v goes off the board", name, m)
+				} else if tt := pf.get(m.toX, m.fromY); tt == tileWall || tt == tileBg {
+					t.Errorf("%s: move %v goes into %s", name, m, tt.name())
+				}
+			}
+		}
+	}
+}
+
+func TestDeadTiles(t *testing.T) {
+	// The hearts are walled in separately, and only touch diagonally. The
+	// diamond is alone.
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#H#PPP#.D#PP",
+		"##H#PP####PP",
+		"####PPPPPPPP",
+	)
+	tests := []struct {
+		name      string
+		neighbors []pos
+		goal      tile
+		dead      []pos
+	}{
+		{"4-connectivity", neighbors4, anyTile, []pos{{1, 1}, {8, 1}, {2, 2}}},
+		{"8-connectivity", neighbors8, anyTile, []pos{{8, 1}}},
+		{"goal tile heart", neighbors4, tile0, []pos{{1, 1}, {2, 2}}},
+		{"goal tile diamond", neighbors8, tile1, []pos{{8, 1}}},
+		{"goal tile triangle", neighbors4, tile2, nil},
+	}
+	defer func() { neighbors, goalTile = neighbors4, anyTile }()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			neighbors, goalTile = tc.neighbors, tc.goal
+			if got := pf.deadTiles(); !slices.Equal(got, tc.dead) {
+				t.Errorf("got dead tiles %v, want %v", got, tc.dead)
+			}
+		})
+	}
+}
+
+func TestTileCountsAfterApply(t *testing.T) {
+	// Clearing the hearts thaws the frozen one, and the blockers are
+	// cleared by the first full scan
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#.h........#",
+		"#H.H..BB...#",
+		"############",
+	)
+	pf2 := pf.apply(move{fromY: 2, fromX: 1, toX: 2})
+	want := scanCounts(pf2)
+	if want[tile0] != 1 || want[tileBlocker] != 0 {
+		t.Fatalf("unexpected board after the move:\n%s", pf2.dumpStr())
+	}
+	if got := *pf2.tileCounts(); got != want {
+		t.Errorf("got counts %v, want %v", got, want)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		pf := randomBoard(r)
+		for step := 0; step < 20; step++ {
+			moves := pf.possibleMoves()
+			if len(moves) == 0 {
+				break
+			}
+			m := moves[r.Intn(len(moves))]
+			pf2 := pf.apply(m)
+			if got, want := *pf2.tileCounts(), scanCounts(pf2); got != want {
+				t.Fatalf("move %v: got counts %v, want %v, board before:\n%s", m, got, want, pf.dumpStr())
+			}
+			pf = pf2
+		}
+	}
+}
+
+func TestLowerBoundMoves(t *testing.T) {
+	levels := map[string]*playfield{
+		"level 93": mustParseLevel(level93),
+		"level 95": mustParseLevel(level95),
+		"solved": board(t,
+			"PPPPPPPPPPPP",
+			"#..........#",
+			"############",
+		),
+		"one move": board(t,
+			"PPPPPPPPPPPP",
+			"#H.H.......#",
+			"############",
+		),
+	}
+	for name, pf := range levels {
+		res, solved, _ := solve(pf, newFrontier("bfs"))
+		if !solved {
+			t.Fatalf("%s: not solved", name)
+		}
+		// The bound must hold for every board on the way, not only the
+		// first one
+		cur := pf
+		for i := 0; i <= len(res.path); i++ {
+			if bound, left := cur.lowerBoundMoves(), len(res.path)-i; bound > left {
+				t.Errorf("%s: bound %d after %d moves, but only %d moves left", name, bound, i, left)
+			}
+			if i < len(res.path) {
+				cur = cur.apply(res.path[i])
+			}
+		}
+	}
+}
+
+func TestAstarBound(t *testing.T) {
+	*flagAstarBound = true
+	defer func() { *flagAstarBound = false }()
+	for _, level := range []string{level93, level95} {
+		pf := mustParseLevel(level)
+		if got := pf.lowerBoundMoves(); got != 2 {
+			t.Errorf("got bound %d, want 2", got)
+		}
+		want, _, bfsStats := solve(pf, newFrontier("bfs"))
+		res, solved, stats := solve(pf, newFrontier("astar"))
+		if !solved || len(res.path) != len(want.path) {
+			t.Fatalf("got solved=%v with %d moves, want %d moves", solved, len(res.path), len(want.path))
+		}
+		if stats.Analyzed >= bfsStats.Analyzed {
+			t.Errorf("A* looked at %d boards, BFS only at %d", stats.Analyzed, bfsStats.Analyzed)
+		}
+	}
+}
+
+func BenchmarkApply(b *testing.B) {
+	pf := mustParseLevel(level95)
+	for _, bc := range []struct {
+		name string
+		pf   *playfield
+	}{
+		{"whole board", pf},
+		{"bounds", pf.withBounds()},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			moves := bc.pf.possibleMoves()
+			for i := 0; i < b.N; i++ {
+				for _, m := range moves {
+					bc.pf.apply(m).possibleMoves()
+				}
+			}
+		})
+	}
+}
+
+func TestBackgroundIsSolid(t *testing.T) {
+	// The heart sits on background, the diamond on an empty cell
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#H.......D.#",
+		"#P#######.##",
+		"############",
+	)
+	drops := pf.clone().dropTiles()
+	want := []Event{{Kind: EventDrop, Tile: tile1, From: pos{9, 1}, To: pos{9, 2}}}
+	if !reflect.DeepEqual(drops, want) {
+		t.Errorf("got drops %v, want only the diamond falling %v", drops, want)
+	}
+
+	// Background works as a floor when sliding, too
+	pf = board(t,
+		"PPPPPPPPPPPP",
+		"#H..#PPPPPPP",
+		"#PPP#PPPPPPP",
+		"#####PPPPPPP",
+	)
+	if got, want := pf.possibleMoves(), []move{{1, 1, 2}, {1, 1, 3}}; !slices.Equal(got, want) {
+		t.Errorf("got moves %v, want %v", got, want)
+	}
+}
+
+// captureStdout returns what f prints to stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestAlreadySolved(t *testing.T) {
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#...G.#....#",
+		"############",
+	)
+	res, solved, stats := solve(pf, newFrontier("bfs"))
+	if !solved || len(res.path) != 0 || stats.Analyzed != 0 {
+		t.Errorf("got solved=%v with %d moves after looking at %d boards, want solved with 0 moves right away", solved, len(res.path), stats.Analyzed)
+	}
+	if moves, cnt := countSolutions(pf, 10); moves != 0 || cnt != 1 {
+		t.Errorf("got %d solutions with %d moves, want 1 with 0 moves", cnt, moves)
+	}
+	out := captureStdout(t, func() { reportSolution(pf, res, solved) })
+	if !strings.Contains(out, "already solved") {
+		t.Errorf("got output %q, want it to say the level is already solved", out)
+	}
+}
+
+func TestTrimSolution(t *testing.T) {
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#H.H..G....#",
+		"############",
+	)
+	solving := move{fromY: 1, fromX: 1, toX: 2}
+	redundant := move{fromY: 1, fromX: 6, toX: 7}
+	solution := pf.apply(solving).apply(redundant)
+	if !solution.isSolved() {
+		t.Fatalf("board not solved:\n%s", solution.dumpStr())
+	}
+	if got := trimSolution(pf, solution).path; !slices.Equal(got, []move{solving}) {
+		t.Errorf("got path %v, want %v", got, []move{solving})
+	}
+
+	// Nothing to trim
+	solution = pf.apply(solving)
+	if got := trimSolution(pf, solution).path; !slices.Equal(got, []move{solving}) {
+		t.Errorf("got path %v, want %v", got, []move{solving})
+	}
+}
+
+func TestSeenSetConcurrent(t *testing.T) {
+	// Every goroutine adds all boards, so each board must be new exactly
+	// once
+	r := rand.New(rand.NewSource(1))
+	var boards []tiles
+	for i := 0; i < 500; i++ {
+		boards = append(boards, randomBoard(r).tiles)
+	}
+	defer func(key string) { seenKey = key }(seenKey)
+	for _, key := range seenKeys {
+		t.Run(key, func(t *testing.T) {
+			seenKey = key
+			s := newSeenSet(true)
+			added := make([]int32, len(boards))
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(g int) {
+					defer wg.Done()
+					for i := range boards {
+						// Start at different boards, so that the goroutines
+						// compete for the same ones in different orders
+						j := (i + g*len(boards)/8) % len(boards)
+						if s.add(boards[j]) {
+							atomic.AddInt32(&added[j], 1)
+						}
+					}
+				}(g)
+			}
+			wg.Wait()
+			for i, cnt := range added {
+				if cnt != 1 {
+					t.Errorf("board %d was added %d times", i, cnt)
+				}
+			}
+			if s.size() != len(boards) {
+				t.Errorf("got size %d, want %d", s.size(), len(boards))
+			}
+		})
+	}
+}
+
+// settle lets all tiles of a random board fall, and clears the groups on it
+// with a full scan, so that only moves can form new groups. It also gets a
+// path, so that apply doesn't do the full scan it does for start boards.
+func settle(pf *playfield) *playfield {
+	pf = pf.clone()
+	for len(pf.dropTiles()) > 0 || len(pf.removeTiles()) > 0 {
+	}
+	pf.path = []move{{}}
+	return pf
+}
+
+func TestRemoveTilesNear(t *testing.T) {
+	// After a move, removeTilesNear with the cells that got a new tile must
+	// clear the same groups as a full scan
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		pf := settle(randomBoard(r))
+		for _, m := range pf.possibleMoves() {
+			moved := pf.clone()
+			tt := moved.get(m.fromX, m.fromY)
+			moved.set(m.fromX, m.fromY, tileEmpty)
+			moved.set(m.toX, m.fromY, tt)
+			changed := []pos{{m.toX, m.fromY}}
+			for _, d := range moved.dropTiles() {
+				changed = append(changed, d.To)
+			}
+
+			full, near := moved.clone(), moved.clone()
+			fullClears, nearClears := full.removeTiles(), near.removeTilesNear(changed)
+			if full.tiles != near.tiles || len(fullClears) != len(nearClears) {
+				t.Fatalf("move %v: full scan cleared %v, removeTilesNear cleared %v, board before:\n%s", m, fullClears, nearClears, pf.dumpStr())
+			}
+
+			// The same for all the drop and clear passes of the move,
+			// including thawed tiles
+			for {
+				if len(moved.dropTiles()) > 0 {
+					continue
+				}
+				clears := moved.removeTiles()
+				if len(clears) == 0 {
+					break
+				}
+				for i := range clears {
+					moved.thaw(&clears[i])
+				}
+			}
+			if got := pf.apply(m); got.tiles != moved.tiles {
+				t.Fatalf("move %v: got\n%swith full scans, but\n%swith removeTilesNear, board before:\n%s", m, moved.dumpStr(), got.dumpStr(), pf.dumpStr())
+			}
+		}
+	}
+}
+
+func BenchmarkRemoveTiles(b *testing.B) {
+	// The board after the first move of level 95's solution, right before
+	// looking for groups
+	pf := mustParseLevel(level95)
+	m := pf.possibleMoves()[0]
+	tt := pf.get(m.fromX, m.fromY)
+	pf.set(m.fromX, m.fromY, tileEmpty)
+	pf.set(m.toX, m.fromY, tt)
+	changed := []pos{{m.toX, m.fromY}}
+	for _, d := range pf.dropTiles() {
+		changed = append(changed, d.To)
+	}
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pf.clone().removeTiles()
+		}
+	})
+	b.Run("near", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pf.clone().removeTilesNear(changed)
+		}
+	})
+}
+
+func BenchmarkRemoveTilesLargeGroups(b *testing.B) {
+	// Four 6x6 squares of one tile each: every cell is part of a big group
+	var rows []string
+	for y := 0; y < playfieldH; y++ {
+		if y < playfieldH/2 {
+			rows = append(rows, "DDDDDDTTTTTT")
+		} else {
+			rows = append(rows, "HHHHHHRRRRRR")
+		}
+	}
+	pf, err := playfieldFromString(strings.Join(rows, "\n"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if n := len(pf.clone().removeTiles()); n != 4 {
+		b.Fatalf("removed %d groups, want 4", n)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pf.clone().removeTiles()
+	}
+}
+
+func BenchmarkSeenSet(b *testing.B) {
+	defer func(key string) { seenKey = key }(seenKey)
+	pf := mustParseLevel(level95)
+	for _, key := range seenKeys {
+		b.Run(key, func(b *testing.B) {
+			seenKey = key
+			b.ReportAllocs()
+			var analyzed int
+			var heap uint64
+			for i := 0; i < b.N; i++ {
+				_, solved, stats := solve(pf, newFrontier("bfs"))
+				if !solved {
+					b.Fatal("not solved")
+				}
+				analyzed += stats.Analyzed
+				heap = max(heap, stats.HeapInUse)
+			}
+			b.ReportMetric(float64(analyzed)/b.Elapsed().Seconds(), "boards/s")
+			b.ReportMetric(float64(heap)/(1<<20), "heap-MiB")
+		})
+	}
+}
+
+func TestSolveIsDeterministic(t *testing.T) {
+	pf := mustParseLevel(level95)
+	for _, algo := range algoNames {
+		t.Run(algo, func(t *testing.T) {
+			first, _, _ := solve(pf, newFrontier(algo))
+			for i := 0; i < 3; i++ {
+				res, _, _ := solve(pf, newFrontier(algo))
+				if !slices.Equal(res.path, first.path) {
+					t.Fatalf("got %s, but %s the first time", formatMoves(res.path), formatMoves(first.path))
+				}
+			}
+		})
+	}
+
+	// Moves are in canonical order
+	moves := pf.possibleMoves()
+	if !slices.IsSortedFunc(moves, func(a, b move) int {
+		if a.less(b) {
+			return -1
+		}
+		if b.less(a) {
+			return 1
+		}
+		return 0
+	}) {
+		t.Errorf("moves %v are not in canonical order", moves)
+	}
+}
+
+func TestMirrorAndRotate(t *testing.T) {
+	pf := mustParseLevel(level95)
+	locked, err := parseLocked("3,3 6,4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf.locked = locked
+	pf.path = []move{{fromY: 3, fromX: 4, toX: 6}}
+
+	same := func(name string, got *playfield) {
+		t.Helper()
+		if got.tiles != pf.tiles || *got.locked != *pf.locked {
+			t.Errorf("%s: got\n%swant\n%s", name, got.dumpStr(), pf.dumpStr())
+		}
+	}
+	same("mirrorX twice", pf.mirrorX().mirrorX())
+	if got := pf.mirrorX().mirrorX().path; !slices.Equal(got, pf.path) {
+		t.Errorf("mirrorX twice: got path %v, want %v", got, pf.path)
+	}
+	same("mirrorY twice", pf.mirrorY().mirrorY())
+	same("rotate90 four times", pf.rotate90().rotate90().rotate90().rotate90())
+	if pf.mirrorX().tiles == pf.tiles || pf.rotate90().tiles == pf.tiles {
+		t.Error("transforms don't change the board")
+	}
+}
+
+func TestMirroredSolution(t *testing.T) {
+	pf := mustParseLevel(level95)
+	res, solved, _ := solve(pf, newFrontier("bfs"))
+	if !solved {
+		t.Fatal("level 95 not solved")
+	}
+	mirrored := pf.mirrorX()
+	if !replaySolves(mirrored, res.mirrorX().path) {
+		t.Errorf("mirrored solution %s doesn't solve the mirrored level", formatMoves(res.mirrorX().path))
+	}
+	res2, solved2, _ := solve(mirrored, newFrontier("bfs"))
+	if !solved2 || len(res2.path) != len(res.path) {
+		t.Errorf("got solved=%v with %d moves for the mirrored level, want %d moves", solved2, len(res2.path), len(res.path))
+	}
+}
+
+func TestOptimizeMoves(t *testing.T) {
+	*flagOptimize = "moves"
+	defer func() { *flagOptimize = "" }()
+	// On these boards, the first path found to some board on the way to
+	// the solution is not the cheapest one to it.
+	walls := strings.Repeat("############\n", 6)
+	tests := []struct {
+		level    string
+		moves    int
+		distance int
+	}{
+		{walls + "##.......###\n##......T###\n##T.....D###\n##D.....####\n##T...TD.###\n############", 5, 7},
+		{walls + "##.......###\n##....T..###\n##...H#H.###\n##..T#.DH###\n##..H..TD###\n############", 5, 6},
+		{walls + "##..D...####\n##..H....###\n##..#D...###\n##D..T...###\n##T..H#..###\n############", 5, 5},
+	}
+	for _, tc := range tests {
+		pf := mustParseLevel(tc.level)
+		res, solved, _ := solve(pf, newFrontier("bfs"))
+		if !solved || len(res.path) != tc.moves {
+			t.Fatalf("got solved=%v with %d moves, want %d moves", solved, len(res.path), tc.moves)
+		}
+		if !replaySolves(pf, res.path) {
+			t.Errorf("solution %s doesn't solve the level", formatMoves(res.path))
+		}
+		if got := res.moveDistance(); got != tc.distance {
+			t.Errorf("got solution %s with distance %d, want %d\n%s", formatMoves(res.path), got, tc.distance, pf.dumpStr())
+		}
+	}
+}
+
+// screenshot draws the boards on a black image of the given size, with
+// their top left corners at the given points.
+func screenshot(w, h int, boards map[image.Point]*playfield) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	for o, pf := range boards {
+		b := pf.toImage()
+		draw.Draw(img, b.Bounds().Add(o), b, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func TestScreenshotBoardIndex(t *testing.T) {
+	pf93, pf95 := mustParseLevel(level93), mustParseLevel(level95)
+	boardW := playfieldW * tileW
+	one := screenshot(boardW+20, playfieldH*tileH+20, map[image.Point]*playfield{{10, 10}: pf93})
+	two := screenshot(2*boardW+40, playfieldH*tileH+50, map[image.Point]*playfield{{10, 10}: pf93, {boardW + 30, 40}: pf95})
+
+	_, _, err := ParseScreenshot(two, ScreenshotOptions{})
+	var multiErr *MultipleBoardsError
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("got error %v, want a MultipleBoardsError", err)
+	}
+	if want := []image.Point{{10, 10}, {boardW + 30, 40}}; !slices.Equal(multiErr.Origins, want) {
+		t.Errorf("got boards at %v, want %v", multiErr.Origins, want)
+	}
+
+	for i, want := range []*playfield{pf93, pf95} {
+		pf, _, err := ParseScreenshot(two, ScreenshotOptions{Board: i + 1})
+		if err != nil {
+			t.Fatalf("board %d: %v", i+1, err)
+		}
+		if pf.tiles != want.tiles {
+			t.Errorf("board %d: got\n%swant\n%s", i+1, pf.dumpStr(), want.dumpStr())
+		}
+	}
+
+	var notFound *PlayfieldNotFoundError
+	if _, _, err := ParseScreenshot(two, ScreenshotOptions{Board: 3}); !errors.As(err, &notFound) {
+		t.Errorf("board 3 of 2: got error %v, want a PlayfieldNotFoundError", err)
+	}
+	if _, _, err := ParseScreenshot(one, ScreenshotOptions{Board: 2}); !errors.As(err, &notFound) {
+		t.Errorf("board 2 of 1: got error %v, want a PlayfieldNotFoundError", err)
+	}
+	if pf, _, err := ParseScreenshot(one, ScreenshotOptions{Board: 1}); err != nil || pf.tiles != pf93.tiles {
+		t.Errorf("board 1 of 1: got error %v", err)
+	}
+}
+
+func TestResumeCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.gob")
+	*flagCheckpoint, *flagCheckpointEvery = path, 0
+	defer func() { *flagCheckpoint, *flagCheckpointEvery = "", 10*time.Minute }()
+	pf := mustParseLevel(level93)
+	// Writes a checkpoint every 1000 boards, the last one is from before
+	// the solution was found.
+	want, _, wantStats := solve(pf, newFrontier("bfs"))
+	*flagCheckpoint = ""
+
+	c, err := readCheckpoint(path, pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Analyzed == 0 || c.Analyzed >= wantStats.Analyzed {
+		t.Fatalf("checkpoint after %d boards, want one in the middle of the %d", c.Analyzed, wantStats.Analyzed)
+	}
+	resumeFrom = c
+	res, solved, stats := solve(pf, newFrontier("bfs"))
+	resumeFrom = nil
+	if !solved || !slices.Equal(res.path, want.path) {
+		t.Errorf("got %s after resuming, want %s", formatMoves(res.path), formatMoves(want.path))
+	}
+	if stats.Analyzed != wantStats.Analyzed {
+		t.Errorf("looked at %d boards after resuming, want %d", stats.Analyzed, wantStats.Analyzed)
+	}
+
+	// Different settings
+	locked, err := parseLocked("5,5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockedPf := pf.clone()
+	lockedPf.locked = locked
+	for _, tc := range []struct {
+		name   string
+		change func()
+		pf     *playfield
+	}{
+		{"connectivity", func() { neighbors = neighbors8 }, pf},
+		{"goal-tile", func() { goalTile = tile2 }, pf},
+		{"goal-remaining", func() { goalRemaining = 2 }, pf},
+		{"beam", func() { *flagBeam = 10 }, pf},
+		{"seen-key", func() { seenKey = "tiles" }, pf},
+		{"frozen", func() { *flagFrozen = false }, pf},
+		{"locked", func() {}, lockedPf},
+		{"algo", func() { *flagAlgo = "astar" }, pf},
+	} {
+		tc.change()
+		_, err := readCheckpoint(path, tc.pf)
+		neighbors, goalTile, goalRemaining, *flagBeam, seenKey, *flagFrozen, *flagAlgo = neighbors4, anyTile, 0, 0, "compact", true, "bfs"
+		if err == nil || !strings.Contains(err.Error(), "-"+tc.name) {
+			t.Errorf("%s: got error %v", tc.name, err)
+		}
+	}
+}
+
+func TestParseScreenshotOptions(t *testing.T) {
+	pf := mustParseLevel(level95)
+	img := screenshot(playfieldW*tileW+20, playfieldH*tileH+20, map[image.Point]*playfield{{10, 10}: pf})
+	got, _, err := ParseScreenshot(img, ScreenshotOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.tiles != pf.tiles {
+		t.Fatalf("got\n%swant\n%s", got.dumpStr(), pf.dumpStr())
+	}
+
+	// One wrong pixel in the middle of every cell
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			px, py := 10+x*tileW+tileW/2, 10+y*tileH+tileH/2
+			if colToInt(img.At(px, py), 0) == 0 {
+				img.Set(px, py, color.White)
+			} else {
+				img.Set(px, py, color.Black)
+			}
+		}
+	}
+	var recErr *TileRecognitionError
+	if _, _, err := ParseScreenshot(img, ScreenshotOptions{}); !errors.As(err, &recErr) {
+		t.Errorf("got error %v without tolerance, want a TileRecognitionError", err)
+	}
+	got, _, err = ParseScreenshot(img, ScreenshotOptions{Tolerance: 1})
+	if err != nil {
+		t.Fatalf("with tolerance: %v", err)
+	}
+	if got.tiles != pf.tiles {
+		t.Errorf("with tolerance, got\n%swant\n%s", got.dumpStr(), pf.dumpStr())
+	}
+}
+
+// solveAndCheck solves pf with breadth-first search, and checks that the
+// solution has the given number of moves and solves pf. Returns the
+// solution.
+func solveAndCheck(t *testing.T, pf *playfield, moves int) *playfield {
+	t.Helper()
+	res, solved, _ := solve(pf, newFrontier("bfs"))
+	if !solved || len(res.path) != moves {
+		t.Fatalf("got solved=%v with %d moves, want %d moves", solved, len(res.path), moves)
+	}
+	if !replaySolves(pf, res.path) {
+		t.Fatalf("solution %s doesn't solve the level", formatMoves(res.path))
+	}
+	return res
+}
+
+func TestBlockers(t *testing.T) {
+	// Clearing the hearts drops the upper blocker onto the lower one
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#B.....#PPPP",
+		"#H...H.#PPPP",
+		"#B######PPPP",
+		"########PPPP",
+	)
+	for _, m := range pf.possibleMoves() {
+		if pf.get(m.fromX, m.fromY) == tileBlocker {
+			t.Errorf("got move %v of a blocker", m)
+		}
+	}
+	res := solveAndCheck(t, pf, 1)
+	cur := pf
+	for _, m := range res.path {
+		if cur.get(m.fromX, m.fromY) == tileBlocker {
+			t.Errorf("solution moves the blocker at (%d,%d)", m.fromX, m.fromY)
+		}
+		cur = cur.apply(m)
+	}
+	if got := scanCounts(cur)[tileBlocker]; got != 0 {
+		t.Errorf("got %d blockers left, want 0", got)
+	}
+}
+
+func TestFrozenTiles(t *testing.T) {
+	// The frozen diamond floats until the hearts next to it are cleared
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#dH..H.#PPPP",
+		"#.####.#PPPP",
+		"#D######PPPP",
+		"########PPPP",
+	)
+	for _, m := range pf.possibleMoves() {
+		if pf.get(m.fromX, m.fromY).isFrozen() {
+			t.Errorf("got move %v of a frozen tile", m)
+		}
+	}
+	// Not next to the frozen tile
+	pf2 := pf.apply(move{fromX: 5, fromY: 1, toX: 6})
+	if pf2.get(1, 1) != tile1|tileFrozen {
+		t.Errorf("frozen tile changed without a clear next to it:\n%s", pf2.dumpStr())
+	}
+	solveAndCheck(t, pf, 1)
+}
+
+func TestScreenshotErrors(t *testing.T) {
+	pf := mustParseLevel(level95)
+	boardW, boardH := playfieldW*tileW, playfieldH*tileH
+	garbled := screenshot(boardW+20, boardH+20, map[image.Point]*playfield{{10, 10}: pf})
+	// Cell (3,4) becomes a white square
+	draw.Draw(garbled, image.Rect(10+3*tileW, 10+4*tileH, 10+4*tileW, 10+5*tileH), image.NewUniform(color.White), image.Point{}, draw.Src)
+	small := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	draw.Draw(small, small.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	t.Run("ScreenshotDecodeError", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "shot.png")
+		if err := os.WriteFile(path, []byte("not an image"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var decodeErr *ScreenshotDecodeError
+		if _, _, err := playfieldFromScreenshot(path, ScreenshotOptions{}); !errors.As(err, &decodeErr) || decodeErr.Path != path {
+			t.Errorf("got error %v, want a ScreenshotDecodeError for %s", err, path)
+		}
+	})
+	t.Run("PlayfieldNotFoundError", func(t *testing.T) {
+		var notFound *PlayfieldNotFoundError
+		if _, _, err := ParseScreenshot(small, ScreenshotOptions{}); !errors.As(err, &notFound) {
+			t.Errorf("got error %v, want a PlayfieldNotFoundError", err)
+		}
+	})
+	t.Run("TileRecognitionError", func(t *testing.T) {
+		var recErr *TileRecognitionError
+		got, _, err := ParseScreenshot(garbled, ScreenshotOptions{})
+		if !errors.As(err, &recErr) {
+			t.Fatalf("got error %v, want a TileRecognitionError", err)
+		}
+		if want := []pos{{3, 4}}; !slices.Equal(recErr.Cells, want) {
+			t.Errorf("got unrecognized cells %v, want %v", recErr.Cells, want)
+		}
+		if got == nil || got.get(3, 4) != tileBg {
+			t.Errorf("unrecognized cell is not read as background")
+		}
+	})
+	t.Run("MultipleBoardsError", func(t *testing.T) {
+		two := screenshot(2*boardW+40, boardH+20, map[image.Point]*playfield{{10, 10}: pf, {boardW + 30, 10}: pf})
+		var multiErr *MultipleBoardsError
+		if _, _, err := ParseScreenshot(two, ScreenshotOptions{}); !errors.As(err, &multiErr) || len(multiErr.Origins) != 2 {
+			t.Errorf("got error %v, want a MultipleBoardsError with 2 boards", err)
+		}
+	})
+}
+
+func TestBlankScreenshot(t *testing.T) {
+	blank := image.NewRGBA(image.Rect(0, 0, 320, 200))
+	draw.Draw(blank, blank.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	for _, img := range []image.Image{blank, image.NewRGBA(image.Rect(0, 0, 0, 0))} {
+		var notFound *PlayfieldNotFoundError
+		if _, _, err := ParseScreenshot(img, ScreenshotOptions{}); !errors.As(err, &notFound) {
+			t.Errorf("%v: got error %v, want a PlayfieldNotFoundError", img.Bounds(), err)
+		}
+	}
+}
+
+func TestScreenshotCrop(t *testing.T) {
+	pf := mustParseLevel(level93)
+	boardW, boardH := playfieldW*tileW, playfieldH*tileH
+	// A window with a title bar and a menu around the C64 screen
+	img := screenshot(boardW+100, boardH+80, map[image.Point]*playfield{{60, 50}: pf})
+	draw.Draw(img, image.Rect(0, 0, boardW+100, 20), image.NewUniform(color.RGBA{200, 200, 200, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 20, 30, boardH+80), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	if got, _, err := ParseScreenshot(img, ScreenshotOptions{}); err == nil && got.tiles == pf.tiles {
+		t.Errorf("read the board without cropping")
+	}
+	crop, err := parseCrop(fmt.Sprintf("40,30,%d,%d", boardW+40, boardH+40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := ParseScreenshot(img, ScreenshotOptions{Crop: crop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.tiles != pf.tiles {
+		t.Errorf("got\n%swant\n%s", got.dumpStr(), pf.dumpStr())
+	}
+}
+
+func TestScreenshotCursor(t *testing.T) {
+	pf := mustParseLevel(level93)
+	img := screenshot(playfieldW*tileW+20, playfieldH*tileH+20, map[image.Point]*playfield{{10, 10}: pf})
+	if _, cursor, err := ParseScreenshot(img, ScreenshotOptions{}); err != nil || cursor != nil {
+		t.Fatalf("got cursor %v and error %v without a cursor", cursor, err)
+	}
+
+	// A white frame around the empty cell (3,6), in the margin that tile
+	// recognition ignores
+	cell := image.Rect(10+3*tileW, 10+6*tileH, 10+4*tileW, 10+7*tileH)
+	draw.Draw(img, cell, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(img, cell.Inset(screenshotMargin), pf.toImage(), image.Pt(3*tileW, 6*tileH).Add(image.Pt(screenshotMargin, screenshotMargin)), draw.Src)
+	got, cursor, err := ParseScreenshot(img, ScreenshotOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.tiles != pf.tiles {
+		t.Errorf("cursor changed the board to\n%s", got.dumpStr())
+	}
+	if want := (pos{3, 6}); cursor == nil || *cursor != want {
+		t.Errorf("got cursor at %v, want %v", cursor, want)
+	}
+}
+
+func TestScreenshotAmbiguousCell(t *testing.T) {
+	// Find two tiles that differ in an even number of pixels, so a cell
+	// can be exactly in between, with no third tile as close
+	tilesImg := tilesImage()
+	inner := image.Rect(screenshotMargin, screenshotMargin, tileW-screenshotMargin, tileH-screenshotMargin)
+	diff := func(t1, t2 tile) []image.Point {
+		var res []image.Point
+		for y := inner.Min.Y; y < inner.Max.Y; y++ {
+			for x := inner.Min.X; x < inner.Max.X; x++ {
+				if colToInt(tilesImg.At(int(t1)*tileW+x, y), 0) != colToInt(tilesImg.At(int(t2)*tileW+x, y), 0) {
+					res = append(res, image.Pt(x, y))
+				}
+			}
+		}
+		return res
+	}
+	var a, b tile
+	var between []image.Point
+pairs:
+	for a = tile0; a <= tile8; a++ {
+		for b = a + 1; b <= tile8; b++ {
+			if between = diff(a, b); len(between)%2 != 0 {
+				continue
+			}
+			between = between[:len(between)/2]
+			for c := tile0; c < tileBlocker; c++ {
+				if c != a && c != b && len(diff(a, c)) <= len(between) {
+					continue pairs
+				}
+			}
+			break pairs
+		}
+	}
+	if a > tile8 {
+		t.Fatal("no two tiles to put a cell in between")
+	}
+
+	pf := board(t, "PPPPPPPPPPPP", "#..........#", "############")
+	pf.set(3, 1, a)
+	img := screenshot(playfieldW*tileW+20, playfieldH*tileH+20, map[image.Point]*playfield{{10, 10}: pf})
+	cell := image.Pt(10+3*tileW, 10+tileH)
+	// Looks like a, with some of the pixels that differ from b taken from
+	// b: with half of them, it's just as close to both
+	set := func(pixels []image.Point) {
+		for _, p := range pixels {
+			img.Set(cell.X+p.X, cell.Y+p.Y, tilesImg.At(int(b)*tileW+p.X, p.Y))
+		}
+	}
+	tolerance := len(between)
+	set(between[:len(between)-1])
+	got, _, err := ParseScreenshot(img, ScreenshotOptions{Tolerance: tolerance})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.get(3, 1) != a {
+		t.Errorf("cell closer to %s: got %s", a.name(), got.get(3, 1).name())
+	}
+	set(between)
+	var recErr *TileRecognitionError
+	if _, _, err := ParseScreenshot(img, ScreenshotOptions{Tolerance: tolerance}); !errors.As(err, &recErr) || !slices.Equal(recErr.Cells, []pos{{3, 1}}) {
+		t.Errorf("cell between %s and %s: got error %v, want a TileRecognitionError for (3,1)", a.name(), b.name(), err)
+	}
+}
+
+func TestScreenshotDarkBorder(t *testing.T) {
+	pf := mustParseLevel(level95)
+	boardW, boardH := playfieldW*tileW, playfieldH*tileH
+	img := image.NewRGBA(image.Rect(0, 0, boardW+20, boardH+20))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{40, 40, 48, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(10, 10, 10+boardW, 10+boardH), pf.toImage(), image.Point{}, draw.Src)
+
+	if got, _, err := ParseScreenshot(img, ScreenshotOptions{}); err == nil && got.tiles == pf.tiles {
+		t.Errorf("read the board although the border isn't black")
+	}
+	got, _, err := ParseScreenshot(img, ScreenshotOptions{Black: 48})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.tiles != pf.tiles {
+		t.Errorf("got\n%swant\n%s", got.dumpStr(), pf.dumpStr())
+	}
+}
+
+func TestScreenshotMirrored(t *testing.T) {
+	pf := mustParseLevel(level95)
+	img := flipImage(screenshot(playfieldW*tileW+20, playfieldH*tileH+20, map[image.Point]*playfield{{10, 10}: pf}), image.Rectangle{}, true, false)
+	if got, _, err := ParseScreenshot(img, ScreenshotOptions{}); err == nil && got.tiles == pf.tiles {
+		t.Errorf("read the mirrored board without -screenshot-flipped")
+	}
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	got, _, err := ParseScreenshot(img, ScreenshotOptions{Flipped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.tiles != pf.tiles {
+		t.Errorf("got\n%swant\n%s", got.dumpStr(), pf.dumpStr())
+	}
+	if !strings.Contains(logs.String(), `flipped="mirrored left to right"`) {
+		t.Errorf("mirroring not reported, got logs %q", logs.String())
+	}
+}
+
+func TestToImage(t *testing.T) {
+	pf := board(t, "PPPPPPPPPPPP", "#..........#", "#..H.....B.#", "############")
+	img := pf.toImage()
+	if want := image.Rect(0, 0, playfieldW*tileW, playfieldH*tileH); img.Bounds() != want {
+		t.Fatalf("got bounds %v, want %v", img.Bounds(), want)
+	}
+	tilesImg := tilesImage()
+	for _, c := range []struct {
+		x, y int
+		t    tile
+	}{{3, 2, tile0}, {0, 0, tileBg}, {1, 1, tileEmpty}, {0, 3, tileWall}} {
+		for y := 0; y < tileH; y++ {
+			for x := 0; x < tileW; x++ {
+				got := img.At(c.x*tileW+x, c.y*tileH+y)
+				want := color.RGBAModel.Convert(tilesImg.At(int(c.t)*tileW+x, y))
+				if got != want {
+					t.Fatalf("%s at (%d,%d): got %v at (%d,%d) of the cell, want %v", c.t.name(), c.x, c.y, got, x, y, want)
+				}
+			}
+		}
+	}
+	// Blockers have no sprite, they are an orange block with a black frame
+	if got, want := img.At(9*tileW, 2*tileH), (color.RGBA{0, 0, 0, 255}); got != want {
+		t.Errorf("blocker frame: got %v, want %v", got, want)
+	}
+	if got, want := img.At(9*tileW+tileW/2, 2*tileH+tileH/2), (color.RGBA{255, 140, 0, 255}); got != want {
+		t.Errorf("blocker: got %v, want %v", got, want)
+	}
+}
+
+func TestLevelRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	levels := []*playfield{mustParseLevel(level93), mustParseLevel(level95)}
+	for i := 0; i < 20; i++ {
+		// Tiles the screenshot parser knows, with background around, as
+		// in the game
+		var pf playfield
+		pf.fill(tileBg)
+		for y := 1; y < playfieldH; y++ {
+			for x := 1; x < playfieldW; x++ {
+				pf.set(x, y, tile(r.Intn(int(tileEmpty)+1)))
+			}
+		}
+		levels = append(levels, &pf)
+	}
+	for _, pf := range levels {
+		got, _, err := ParseScreenshot(pf.toImage(), ScreenshotOptions{})
+		if err != nil {
+			t.Fatalf("%v reading\n%s", err, pf.dumpStr())
+		}
+		if got.tiles != pf.tiles {
+			t.Errorf("screenshot: got\n%swant\n%s", got.dumpStr(), pf.dumpStr())
+		}
+		if got := decodeLevel(encodeLevel(pf)); got != pf.dumpStr() {
+			t.Errorf("base64: got\n%swant\n%s", got, pf.dumpStr())
+		}
+	}
+}
+
+func TestTilesetSize(t *testing.T) {
+	img, err := png.Decode(bytes.NewReader(tilesData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := img.Bounds().Size(), image.Pt(12*16, 16); got != want {
+		t.Errorf("tiles.png is %v, want %v", got, want)
+	}
+	if err := checkTilesetSize(image.NewRGBA(image.Rect(0, 0, 11*16, 16))); err == nil {
+		t.Error("tileset with 11 tiles accepted")
+	}
+}
+
+func TestLoadTextureGarbage(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("not a PNG"), fontData[:len(fontData)/2]} {
+		if _, err := loadTexture(nil, data); err == nil {
+			t.Errorf("loading %d bytes of garbage: got no error", len(data))
+		}
+	}
+}
+
+func TestLevelFromBin(t *testing.T) {
+	pf := mustParseLevel(level95)
+	var data []byte
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			data = append(data, byte(pf.get(x, y)))
+		}
+	}
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	got, err := levelFromBin(write("level.bin", data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != pf.dumpStr() {
+		t.Errorf("got\n%swant\n%s", got, pf.dumpStr())
+	}
+
+	// Frozen ring at (5,4)
+	frozen := slices.Clone(data)
+	frozen[4*playfieldW+5] |= 0x80
+	if got, err := levelFromBin(write("frozen.bin", frozen)); err != nil || got[4*(playfieldW+1)+5] != 'r' {
+		t.Errorf("got error %v and\n%swant a frozen ring at (5,4)", err, got)
+	}
+
+	for name, bad := range map[string][]byte{
+		"short.bin":        data[:len(data)-1],
+		"long.bin":         append(slices.Clone(data), 0),
+		"out-of-range.bin": append(slices.Clone(data[:len(data)-1]), byte(tileBlocker+1)),
+		"frozen-wall.bin":  append(slices.Clone(data[:len(data)-1]), byte(tileWall)|0x80),
+	} {
+		if _, err := levelFromBin(write(name, bad)); err == nil {
+			t.Errorf("%s: got no error", name)
+		}
+	}
+}
+
+func TestDumpStrRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	levels := []*playfield{mustParseLevel(level93), mustParseLevel(level95)}
+	for i := 0; i < 100; i++ {
+		levels = append(levels, randomBoard(r))
+	}
+	for _, pf := range levels {
+		got, err := playfieldFromString(pf.dumpStr())
+		if err != nil {
+			t.Fatalf("%v reading\n%s", err, pf.dumpStr())
+		}
+		if got.tiles != pf.tiles {
+			t.Errorf("got\n%swant\n%s", got.dumpStr(), pf.dumpStr())
+		}
+	}
+}
+
+func TestTileMapping(t *testing.T) {
+	var all []tile
+	for tt := tile0; tt <= tileBlocker; tt++ {
+		all = append(all, tt)
+	}
+	for tt := tile0; tt <= tile7; tt++ {
+		all = append(all, tt|tileFrozen)
+	}
+	for _, tt := range all {
+		c, found := tileToChar[tt]
+		if !found {
+			t.Errorf("%s has no character", tt.name())
+			continue
+		}
+		if got, found := charToTile[c]; !found || got != tt {
+			t.Errorf("'%c' is read as %v, want %s", c, got, tt.name())
+		}
+	}
+	if len(charToTile) != len(all) || len(tileToChar) != len(all) {
+		t.Errorf("got %d characters for %d tiles, want %d", len(charToTile), len(tileToChar), len(all))
+	}
+}
+
+func TestSaveScreenshot(t *testing.T) {
+	pf := mustParseLevel(level95)
+	res, _, _ := solve(pf, newFrontier("bfs"))
+	for _, b := range []*playfield{pf, pf.apply(res.path[0]), res} {
+		path := filepath.Join(t.TempDir(), "board.png")
+		if err := savePNG(path, b.toImage()); err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := playfieldFromScreenshot(path, ScreenshotOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.tiles != b.tiles {
+			t.Errorf("got\n%swant\n%s", got.dumpStr(), b.dumpStr())
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	pf := mustParseLevel(level95)
+	if pf.Hash() != mustParseLevel(level95).Hash() {
+		t.Error("equal boards have different hashes")
+	}
+	pf2 := pf.clone()
+	pf2.path = []move{{fromX: 4, fromY: 3, toX: 6}}
+	if pf2.Hash() != pf.Hash() {
+		t.Error("the path changes the hash")
+	}
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			for _, tt := range []tile{tile0, tile2 | tileFrozen, tileEmpty} {
+				if pf.get(x, y) == tt {
+					continue
+				}
+				pf2 := pf.clone()
+				pf2.set(x, y, tt)
+				if pf2.Hash() == pf.Hash() {
+					t.Errorf("setting (%d,%d) to %s doesn't change the hash", x, y, tt.name())
+				}
+			}
+		}
+	}
+
+	// Zobrist hashes can be updated cell by cell, so the hash after a move
+	// is the old one with the keys of the changed cells swapped
+	cellKey := func(x, y int, tt tile) uint64 {
+		k := zobristKeys[y][x][tt.base()]
+		if tt.isFrozen() {
+			k ^= zobristFrozen[y][x]
+		}
+		return k
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		pf := settle(randomBoard(r))
+		moves := pf.possibleMoves()
+		if len(moves) == 0 {
+			continue
+		}
+		pf2 := pf.apply(moves[r.Intn(len(moves))])
+		h := pf.Hash()
+		for _, p := range diffTiles(pf.tiles, pf2.tiles) {
+			h ^= cellKey(p.x, p.y, pf.get(p.x, p.y)) ^ cellKey(p.x, p.y, pf2.get(p.x, p.y))
+		}
+		if h != pf2.Hash() {
+			t.Fatalf("updated hash %x, but %x computed from scratch for\n%s", h, pf2.Hash(), pf2.dumpStr())
+		}
+	}
+}
+
+func TestEqualAndDiffTiles(t *testing.T) {
+	pf := mustParseLevel(level95)
+	changed := pf.clone()
+	changed.set(6, 3, tile0)
+	frozen := pf.clone()
+	frozen.set(3, 3, pf.get(3, 3)|tileFrozen)
+	tests := []struct {
+		name string
+		b    *playfield
+		want []pos
+	}{
+		{"identical", mustParseLevel(level95), nil},
+		{"one cell", changed, []pos{{6, 3}}},
+		{"frozen", frozen, []pos{{3, 3}}},
+	}
+	for _, tc := range tests {
+		if got := diffTiles(pf.tiles, tc.b.tiles); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got diff %v, want %v", tc.name, got, tc.want)
+		}
+		if got, want := pf.tiles.equal(tc.b.tiles), tc.want == nil; got != want {
+			t.Errorf("%s: got equal %v, want %v", tc.name, got, want)
+		}
+	}
+}
+
+func TestConnectivityXShape(t *testing.T) {
+	defer func() { neighbors = neighbors4 }()
+	x := board(t,
+		"PPPPPPPPPPPP",
+		"PPPPPPPPPPPP",
+		"PPPPPPPPPPPP",
+		"PPPPPPPPPPPP",
+		"####H#H#####",
+		"#####H######",
+		"####H#H#####",
+		"############",
+	)
+	for _, tc := range []struct {
+		neighbors []pos
+		clears    int
+	}{{neighbors4, 0}, {neighbors8, 1}} {
+		neighbors = tc.neighbors
+		pf := x.clone()
+		clears := pf.removeTiles()
+		if len(clears) != tc.clears {
+			t.Fatalf("%d-connectivity: got %d clears, want %d", len(tc.neighbors), len(clears), tc.clears)
+		}
+		if tc.clears > 0 && len(clears[0].Cells) != 5 {
+			t.Errorf("%d-connectivity: cleared %v, want all 5 hearts", len(tc.neighbors), clears[0].Cells)
+		}
+	}
+}
+
+func TestIgnoreTiles(t *testing.T) {
+	pf := mustParseLevel(level95)
+	ignored := pf.clone()
+	if err := ignored.ignoreTiles("HR"); err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < playfieldH; y++ {
+		for x := 0; x < playfieldW; x++ {
+			want := pf.get(x, y)
+			if want.base() == tile0 || want.base() == tile3 {
+				want = tileWall
+			}
+			if got := ignored.get(x, y); got != want {
+				t.Errorf("(%d,%d): got %s, want %s", x, y, got.name(), want.name())
+			}
+		}
+	}
+	solveAndCheck(t, ignored, 7)
+
+	if err := pf.clone().ignoreTiles("#"); err == nil {
+		t.Error("ignoring walls: got no error")
+	}
+}
+
+func TestLockedSolve(t *testing.T) {
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#H...H#PPPPP",
+		"#######PPPPP",
+	)
+	if res := solveAndCheck(t, pf, 1); res.path[0].fromX != 1 {
+		t.Fatalf("got %s, want the left heart to move without locks", formatMoves(res.path))
+	}
+	locked, err := parseLocked("1,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pf.locked = locked
+	res := solveAndCheck(t, pf, 1)
+	for _, m := range res.path {
+		if locked[m.fromY][m.fromX] {
+			t.Errorf("solution %s moves the locked tile at (%d,%d)", formatMoves(res.path), m.fromX, m.fromY)
+		}
+	}
+}
+
+func TestCycles(t *testing.T) {
+	// The hearts can move back and forth, but never meet
+	pf := board(t,
+		"PPPPPPPPPPPP",
+		"#H.#.H#PPPPP",
+		"#######PPPPP",
+	)
+	there := pf.apply(move{fromX: 1, fromY: 1, toX: 2})
+	back := there.apply(move{fromX: 2, fromY: 1, toX: 1})
+	if back.tiles != pf.tiles {
+		t.Fatalf("moving back and forth gives\n%s", back.dumpStr())
+	}
+	if there.revisits(pf) {
+		t.Error("one move is a cycle")
+	}
+	if !back.revisits(pf) {
+		t.Error("moving back and forth is not a cycle")
+	}
+	if other := there.apply(move{fromX: 5, fromY: 1, toX: 4}); other.revisits(pf) {
+		t.Error("moving both hearts is a cycle")
+	}
+
+	// Without deduplication, the cycle guard is all that keeps the search
+	// from going on forever
+	*flagNoDedup, *flagNoSolvPrune = true, true
+	defer func() { *flagNoDedup, *flagNoSolvPrune = false, false }()
+	done := make(chan bool)
+	go func() {
+		_, solved, _ := solve(pf, newFrontier("bfs"))
+		done <- solved
+	}()
+	select {
+	case solved := <-done:
+		if solved {
+			t.Error("solved a level without a solution")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("search without deduplication doesn't stop")
+	}
+}
+
+func TestGreedy(t *testing.T) {
+	for _, level := range []string{level93, level95} {
+		pf := mustParseLevel(level)
+		_, _, bfsStats := solve(pf, newFrontier("bfs"))
+		res, solved, stats := solve(pf, newFrontier("greedy"))
+		if !solved || !replaySolves(pf, res.path) {
+			t.Fatalf("got solved=%v with %s", solved, formatMoves(res.path))
+		}
+		if stats.Analyzed > bfsStats.Analyzed {
+			t.Errorf("greedy looked at %d boards, BFS only at %d", stats.Analyzed, bfsStats.Analyzed)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	levels := map[string]*playfield{
+		"level 93": mustParseLevel(level93),
+		"level 95": mustParseLevel(level95),
+		"blockers": board(t,
+			"PPPPPPPPPPPP",
+			"#B.....#PPPP",
+			"#H...H.#PPPP",
+			"#B######PPPP",
+			"########PPPP",
+		),
+	}
+	*flagPrune = true
+	defer func() { *flagPrune = false }()
+	for name, pf := range levels {
+		res, solved, _ := solve(pf, newFrontier("bfs"))
+		if !solved {
+			t.Fatalf("%s: not solved with -prune", name)
+		}
+		var ok bool
+		out := captureStdout(t, func() { ok = validateSolution(pf, res.path) })
+		if !ok {
+			t.Errorf("%s: solution %s is wrong: %s", name, formatMoves(res.path), out)
+		}
+	}
+}
+
+func TestBeam(t *testing.T) {
+	pf := mustParseLevel(level95)
+	want, _, _ := solve(pf, newFrontier("bfs"))
+	for _, k := range []int{10, 1000, 100000} {
+		b := newBeam(k)
+		res, solved, _ := solve(pf, b)
+		if solved && !replaySolves(pf, res.path) {
+			t.Fatalf("k=%d: solution %s doesn't solve the level", k, formatMoves(res.path))
+		}
+		missed := !solved || len(res.path) > len(want.path)
+		if missed && b.dropped == 0 {
+			t.Errorf("k=%d: missed the shortest solution, but dropped no boards", k)
+		}
+		if b.dropped == 0 && !slices.Equal(res.path, want.path) {
+			t.Errorf("k=%d: got %s without dropping boards, want %s", k, formatMoves(res.path), formatMoves(want.path))
+		}
+		if k == 10 && !missed {
+			t.Errorf("k=%d: found the shortest solution, the test needs a smaller k", k)
+		}
+		if k == 100000 && b.dropped != 0 {
+			t.Errorf("k=%d: dropped %d boards, the test needs a larger k", k, b.dropped)
+		}
+	}
+}
+
+func TestMaxBranching(t *testing.T) {
+	pf := mustParseLevel(level95)
+	moves := pf.possibleMoves()
+	remaining := func(m move) int {
+		pf2 := pf.apply(m)
+		if !pf2.isSolvable() {
+			return playfieldW * playfieldH
+		}
+		return pf2.heuristic()
+	}
+	const n = 5
+	best := pf.mostPromising(moves, n)
+	if len(best) != n || !slices.IsSortedFunc(best, func(a, b move) int {
+		if a.less(b) {
+			return -1
+		}
+		return 1
+	}) {
+		t.Fatalf("got %v, want %d moves in canonical order", best, n)
+	}
+	for _, m := range moves {
+		if slices.Contains(best, m) {
+			continue
+		}
+		for _, b := range best {
+			if remaining(m) < remaining(b) {
+				t.Errorf("%v leaves %d tiles, but %v with %d tiles was picked", m, remaining(m), b, remaining(b))
+			}
+		}
+	}
+
+	_, _, full := solve(pf, newFrontier("bfs"))
+	*flagMaxBranching = n
+	defer func() { *flagMaxBranching = 0 }()
+	res, solved, stats := solve(pf, newFrontier("bfs"))
+	if !solved || !replaySolves(pf, res.path) {
+		t.Fatalf("got solved=%v with %s", solved, formatMoves(res.path))
+	}
+	if stats.Analyzed >= full.Analyzed {
+		t.Errorf("looked at %d boards, %d without -max-branching", stats.Analyzed, full.Analyzed)
+	}
+}
+
+func TestUndoStack(t *testing.T) {
+	var pfs []*playfield
+	for i := 0; i < 6; i++ {
+		pfs = append(pfs, &playfield{path: make([]move, i)})
+	}
+	u := newUndoStack(pfs[0], 3)
+	check := func(name string, want *playfield) {
+		t.Helper()
+		if got := u.current(); got != want {
+			t.Errorf("%s: at board %d, want %d", name, len(got.path), len(want.path))
+		}
+	}
+	if u.undo() || u.redo() {
+		t.Error("undo or redo without moves")
+	}
+	check("start", pfs[0])
+
+	u.push(pfs[1])
+	u.push(pfs[2])
+	check("after two moves", pfs[2])
+	if !u.undo() {
+		t.Error("can't undo")
+	}
+	check("after undo", pfs[1])
+	if !u.redo() || u.redo() {
+		t.Error("can't redo once")
+	}
+	check("after redo", pfs[2])
+
+	u.undo()
+	u.push(pfs[3])
+	check("after a new move", pfs[3])
+	if u.redo() {
+		t.Error("can redo after a new move")
+	}
+	u.undo()
+	check("undo after a new move", pfs[1])
+
+	// At the limit, the oldest boards are dropped
+	u.push(pfs[3])
+	u.push(pfs[4])
+	u.push(pfs[5])
+	undos := 0
+	for u.undo() {
+		undos++
+	}
+	if undos != 3 {
+		t.Errorf("got %d undos, want the limit of 3", undos)
+	}
+	check("after undoing everything", pfs[1])
+}